@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// -reuse-accounts で保存するアカウントの認証情報
+type storedAccount struct {
+	AccountName string `json:"account_name"`
+	Password    string `json:"password"`
+}
+
+// 以前の実行で登録したアカウントを保存し、次の実行で再利用するための構造体
+// initialize の遅いアプリケーションで、実行のたびにユーザー登録し直さなくて済むようにする
+type AccountStore struct {
+	mu   sync.Mutex
+	path string
+	// ファイルから読み込んだまだ使っていないアカウント
+	stored []storedAccount
+	// この実行でログインできたか登録したアカウント
+	valid []storedAccount
+}
+
+// ファイルからアカウントを読み込んだ AccountStore を生成
+// ファイルがなければ空の AccountStore を返す
+func LoadAccountStore(path string) (*AccountStore, error) {
+	store := &AccountStore{
+		path:   path,
+		stored: []storedAccount{},
+		valid:  []storedAccount{},
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&store.stored); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// まだ使っていない保存済みのアカウントを 1 つ取り出す
+func (a *AccountStore) Take() (*User, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.stored) == 0 {
+		return nil, false
+	}
+
+	account := a.stored[0]
+	a.stored = a.stored[1:]
+
+	return &User{AccountName: account.AccountName, Password: account.Password}, true
+}
+
+// 次の実行で再利用するアカウントとして記録
+func (a *AccountStore) Add(user *User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.valid = append(a.valid, storedAccount{AccountName: user.AccountName, Password: user.Password})
+}
+
+// 記録したアカウントと、この実行で使わなかった保存済みのアカウントでファイルを書き直す
+// ログインできなかったアカウントは含めない
+func (a *AccountStore) Save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.Create(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	accounts := append(append([]storedAccount{}, a.valid...), a.stored...)
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(accounts)
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/isucon/isucandar/agent"
 )
@@ -68,6 +71,21 @@ func GetRootAction(ctx context.Context, ag *agent.Agent) (*http.Response, error)
 	return ag.Do(ctx, req)
 }
 
+// アプリケーションが max_created_at のパースに使う日時の形式
+const ISO8601Format = "2006-01-02T15:04:05-07:00"
+
+// GET /posts?max_created_at= を送信
+func GetPostsAction(ctx context.Context, ag *agent.Agent, maxCreatedAt time.Time) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.GET("/posts?max_created_at=" + url.QueryEscape(maxCreatedAt.Format(ISO8601Format)))
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
 // POST / を送信
 func PostRootAction(ctx context.Context, ag *agent.Agent, post *Post, csrfToken string) (*http.Response, error) {
 	img, err := randomImage()
@@ -75,6 +93,11 @@ func PostRootAction(ctx context.Context, ag *agent.Agent, post *Post, csrfToken
 		return nil, err
 	}
 
+	return PostRootWithImageAction(ctx, ag, post, img, "image.png", "image/png", csrfToken)
+}
+
+// 画像のファイル名と Content-Type を指定して POST / を送信
+func PostRootWithImageAction(ctx context.Context, ag *agent.Agent, post *Post, img []byte, filename string, contentType string, csrfToken string) (*http.Response, error) {
 	body := bytes.NewBuffer([]byte{})
 	form := multipart.NewWriter(body)
 
@@ -86,10 +109,10 @@ func PostRootAction(ctx context.Context, ag *agent.Agent, post *Post, csrfToken
 		"Content-Disposition",
 		fmt.Sprintf(
 			`form-data; name="%s"; filename="%s"`,
-			"file", "image.png",
+			"file", filename,
 		),
 	)
-	fileHeader.Set("Content-Type", "image/png")
+	fileHeader.Set("Content-Type", contentType)
 	file, err := form.CreatePart(fileHeader)
 	if err != nil {
 		return nil, err
@@ -110,3 +133,197 @@ func PostRootAction(ctx context.Context, ag *agent.Agent, post *Post, csrfToken
 	// リクエストを実行
 	return ag.Do(ctx, req)
 }
+
+// 画像の後ろを 0 で埋めて size バイトにした POST / を送信
+// 大きなリクエストでもメモリに載せずに送るため、ボディは少しずつ生成する
+func PostRootWithPaddedImageAction(ctx context.Context, ag *agent.Agent, post *Post, img []byte, size int64, csrfToken string) (*http.Response, error) {
+	// 画像の前後のマルチパートの区切りだけを先に組み立てる
+	head := bytes.NewBuffer([]byte{})
+	form := multipart.NewWriter(head)
+
+	form.WriteField("body", post.Body)
+	form.WriteField("csrf_token", csrfToken)
+
+	fileHeader := make(textproto.MIMEHeader)
+	fileHeader.Set(
+		"Content-Disposition",
+		fmt.Sprintf(
+			`form-data; name="%s"; filename="%s"`,
+			"file", "image.png",
+		),
+	)
+	fileHeader.Set("Content-Type", "image/png")
+	if _, err := form.CreatePart(fileHeader); err != nil {
+		return nil, err
+	}
+
+	tail := bytes.NewBuffer([]byte{})
+	tail.WriteString("\r\n--" + form.Boundary() + "--\r\n")
+
+	padding := size - int64(len(img))
+	if padding < 0 {
+		padding = 0
+	}
+	length := int64(head.Len()+len(img)+tail.Len()) + padding
+	body := io.MultiReader(head, bytes.NewReader(img), io.LimitReader(zeroReader{}, padding), tail)
+
+	// リクエストを生成
+	req, err := ag.POST("/", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", form.FormDataContentType())
+	// サーバーが Content-Length だけでも拒否できるように長さを先に伝える
+	req.ContentLength = length
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// 0 を無限に読み出せる io.Reader
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// GET /register を送信
+func GetRegisterAction(ctx context.Context, ag *agent.Agent) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.GET("/register")
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// POST /register を送信
+func PostRegisterAction(ctx context.Context, ag *agent.Agent, accountName, password string) (*http.Response, error) {
+	values := url.Values{}
+	values.Add("account_name", accountName)
+	values.Add("password", password)
+
+	// リクエストを生成
+	req, err := ag.POST("/register", strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// GET /@:account_name を送信
+func GetUserPageAction(ctx context.Context, ag *agent.Agent, accountName string) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.GET("/@" + url.PathEscape(accountName))
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// GET /posts/:id を送信
+func GetPostAction(ctx context.Context, ag *agent.Agent, postID int) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.GET(fmt.Sprintf("/posts/%d", postID))
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// GET /admin/banned を送信
+func GetAdminBannedAction(ctx context.Context, ag *agent.Agent) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.GET("/admin/banned")
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// POST /comment を送信
+func PostCommentAction(ctx context.Context, ag *agent.Agent, postID int, comment string, csrfToken string) (*http.Response, error) {
+	values := url.Values{}
+	values.Add("post_id", strconv.Itoa(postID))
+	values.Add("comment", comment)
+	values.Add("csrf_token", csrfToken)
+
+	// リクエストを生成
+	req, err := ag.POST("/comment", strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// 任意のメソッドとパスでリクエストを送信
+// 想定外のリクエストに対するアプリケーションの振る舞いを検証するために使う
+func RequestAction(ctx context.Context, ag *agent.Agent, method string, path string) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.NewRequest(method, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// Accept ヘッダを指定して GET リクエストを送信
+// 空文字列を指定すると agent.Agent の既定の Accept ヘッダを送る
+func GetWithAcceptAction(ctx context.Context, ag *agent.Agent, path string, accept string) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.GET(path)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// 任意の HTTP メソッドとパスでリクエストを送信
+// "//" のようなパスも URL として解釈させずにそのまま送る
+func RequestRawPathAction(ctx context.Context, ag *agent.Agent, method string, path string) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.NewRequest(method, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path = path
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
+
+// GET /logout を送信
+func GetLogoutAction(ctx context.Context, ag *agent.Agent) (*http.Response, error) {
+	// リクエストを生成
+	req, err := ag.GET("/logout")
+	if err != nil {
+		return nil, err
+	}
+
+	// リクエストを実行
+	return ag.Do(ctx, req)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucandar"
+	"github.com/isucon/isucandar/worker"
+)
+
+// -autoscale で並列数を見直す間隔
+const AutoscaleInterval = 5 * time.Second
+
+// 負荷走行中にエラー率とレイテンシを見ながらワーカーの並列数を調整する
+// 並列数を倍々に増やし、エラー率かレイテンシが悪化したら直前の並列数に戻して固定する
+type Autoscaler struct {
+	mu      sync.Mutex
+	workers []*worker.Worker
+	// 許容するエラー率
+	errorRate float64
+	// 許容する平均レイテンシ
+	latency time.Duration
+	// 現在のワーカーあたりの並列数
+	parallelism int32
+	// エラーが増える前に到達したワーカーあたりの並列数
+	ceiling int32
+	// 並列数を増やすのをやめたか
+	saturated bool
+}
+
+// ワーカーの並列数を調整する Autoscaler を生成
+func NewAutoscaler(errorRate float64, latency time.Duration) *Autoscaler {
+	return &Autoscaler{
+		workers:   []*worker.Worker{},
+		errorRate: errorRate,
+		latency:   latency,
+	}
+}
+
+// 並列数を調整するワーカーを追加
+// 低い並列数から始めるため並列数は 1 にする
+func (a *Autoscaler) Add(w *worker.Worker) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	w.SetParallelism(1)
+	a.workers = append(a.workers, w)
+}
+
+// ctx が終了するまで AutoscaleInterval おきに並列数を調整
+func (a *Autoscaler) Run(ctx context.Context, step *isucandar.BenchmarkStep) {
+	a.setParallelism(1)
+
+	errors := len(step.Result().Errors.All())
+	requests := telemetry.Requests()
+	latency := telemetry.TotalLatency()
+
+	ticker := time.NewTicker(AutoscaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		currentErrors := len(step.Result().Errors.All())
+		currentRequests := telemetry.Requests()
+		currentLatency := telemetry.TotalLatency()
+
+		errorRate := 0.0
+		averageLatency := time.Duration(0)
+		if n := currentRequests - requests; n > 0 {
+			errorRate = float64(currentErrors-errors) / float64(n)
+			averageLatency = (currentLatency - latency) / time.Duration(n)
+		}
+		errors, requests, latency = currentErrors, currentRequests, currentLatency
+
+		a.adjust(errorRate, averageLatency)
+	}
+}
+
+// 直近のエラー率と平均レイテンシから次の並列数を決める
+func (a *Autoscaler) adjust(errorRate float64, averageLatency time.Duration) {
+	a.mu.Lock()
+	parallelism := a.parallelism
+	saturated := a.saturated
+	a.mu.Unlock()
+
+	if saturated {
+		return
+	}
+
+	if errorRate > a.errorRate || averageLatency > a.latency {
+		// 悪化する前の並列数に戻して固定
+		healthy := parallelism / 2
+		if healthy < 1 {
+			healthy = 1
+		}
+		AdminLogger.Printf("autoscale: parallelism %d: error rate %.3f, latency %s: saturated", parallelism, errorRate, averageLatency.Round(time.Microsecond))
+
+		a.mu.Lock()
+		a.saturated = true
+		a.mu.Unlock()
+		a.setParallelism(healthy)
+		return
+	}
+
+	AdminLogger.Printf("autoscale: parallelism %d: error rate %.3f, latency %s", parallelism, errorRate, averageLatency.Round(time.Microsecond))
+
+	a.mu.Lock()
+	a.ceiling = parallelism
+	a.mu.Unlock()
+	a.setParallelism(parallelism * 2)
+}
+
+// すべてのワーカーの並列数を変更
+func (a *Autoscaler) setParallelism(parallelism int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.parallelism = parallelism
+	for _, w := range a.workers {
+		w.SetParallelism(parallelism)
+	}
+}
+
+// エラーが増える前に到達した全ワーカーの並列数の合計を返す
+func (a *Autoscaler) Ceiling() int32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.ceiling * int32(len(a.workers))
+}
+
+// 大会運営向けロガーにサマリを出力
+func (a *Autoscaler) Print() {
+	a.mu.Lock()
+	saturated := a.saturated
+	a.mu.Unlock()
+
+	if saturated {
+		AdminLogger.Printf("autoscale: max sustainable concurrency: %d", a.Ceiling())
+	} else {
+		// 負荷走行の時間内に悪化しなければ下限値としてしか分からない
+		AdminLogger.Printf("autoscale: max sustainable concurrency: >= %d (not saturated)", a.Ceiling())
+	}
+}
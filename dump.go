@@ -1,15 +1,33 @@
 package main
 
 import (
+	"embed"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path"
 )
 
+// 初期データと一致するアカウント一覧はバイナリに埋め込み、
+// ベンチマーカーを実行するディレクトリに依存せずロードできるようにする
+//
+//go:embed dump/users.json
+var embeddedDump embed.FS
+
+// 埋め込まれたダンプファイルがあればそれを、なければファイルシステム上のファイルを開く
+func openDump(jsonFile string) (io.ReadCloser, error) {
+	if file, err := embeddedDump.Open(path.Clean(jsonFile)); err == nil {
+		return file, nil
+	}
+
+	return os.Open(jsonFile)
+}
+
 // JSON 形式のダンプファイルからモデルの集合をロード
 func (s *Set[T]) LoadJSON(jsonFile string) error {
 	// 引数に渡されたファイルを開く
-	file, err := os.Open(jsonFile)
+	file, err := openDump(jsonFile)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/isucon/isucandar/failure"
+)
+
+// エラーメッセージをまとめる前に、リクエストごとに変わる部分を置き換える規則
+// 上から順に適用する
+var errorMessageNormalizers = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// クエリ文字列の値
+	{regexp.MustCompile(`\?[^\s"]*`), "?<query>"},
+	// IP アドレスとポート番号
+	{regexp.MustCompile(`\[[0-9a-fA-F:]+\](:\d+)?|\b\d{1,3}(\.\d{1,3}){3}(:\d+)?`), "<addr>"},
+	// ホスト名のあとのポート番号
+	{regexp.MustCompile(`\b(localhost|[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)+):\d+`), "$1:<port>"},
+	// パスに含まれる ID やユーザー名
+	{regexp.MustCompile(`/\d+`), "/<id>"},
+	{regexp.MustCompile(`/@[^\s/"]+`), "/@<user>"},
+	// メッセージ中の Post の ID
+	{regexp.MustCompile(`\bpid_\d+`), "pid_<id>"},
+	{regexp.MustCompile(`\bpost \d+`), "post <id>"},
+}
+
+// エラーメッセージからリクエストごとに変わる部分を取り除く
+func NormalizeErrorMessage(message string) string {
+	for _, normalizer := range errorMessageNormalizers {
+		message = normalizer.pattern.ReplaceAllString(message, normalizer.replacement)
+	}
+	return message
+}
+
+// 似たエラーメッセージをまとめて件数を数える構造体
+type ErrorSummary struct {
+	// ステップごとに表示するメッセージの上限 (0 なら無制限)
+	maxPerStep int
+	// 最初に発生した順のメッセージ
+	messages []string
+	counts   map[string]int
+	// メッセージが発生したステップ
+	steps map[string]string
+}
+
+// 空の ErrorSummary を生成
+func NewErrorSummary(maxPerStep int) *ErrorSummary {
+	return &ErrorSummary{
+		maxPerStep: maxPerStep,
+		messages:   []string{},
+		counts:     map[string]int{},
+		steps:      map[string]string{},
+	}
+}
+
+// エラーを件数に加える
+func (s *ErrorSummary) Add(err error) {
+	message := NormalizeErrorMessage(fmt.Sprintf("%v", err))
+	if _, ok := s.counts[message]; !ok {
+		s.messages = append(s.messages, message)
+		s.steps[message] = errorStep(err)
+	}
+	s.counts[message]++
+}
+
+// エラーが発生したステップを返す
+// isucandar はステップ名を最初のエラーコードとして付ける
+func errorStep(err error) string {
+	codes := failure.GetErrorCodes(err)
+	if len(codes) == 0 {
+		return ""
+	}
+	return codes[0]
+}
+
+// まとめたメッセージを最初に発生した順に表示用の行にして返す
+// 2 件以上あれば件数を付け、ステップごとの上限を超えた分は省略した件数だけを返す
+func (s *ErrorSummary) Lines() []string {
+	lines := make([]string, 0, len(s.messages))
+	shown := map[string]int{}
+	omitted := map[string]int{}
+	omittedSteps := []string{}
+	for _, message := range s.messages {
+		step := s.steps[message]
+		count := s.counts[message]
+
+		if s.maxPerStep > 0 && shown[step] >= s.maxPerStep {
+			if omitted[step] == 0 {
+				omittedSteps = append(omittedSteps, step)
+			}
+			omitted[step] += count
+			continue
+		}
+		shown[step]++
+
+		if count > 1 {
+			lines = append(lines, fmt.Sprintf("%s (x%d)", message, count))
+		} else {
+			lines = append(lines, message)
+		}
+	}
+
+	for _, step := range omittedSteps {
+		if step == "" {
+			lines = append(lines, fmt.Sprintf("%d more errors omitted", omitted[step]))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %d more errors omitted", step, omitted[step]))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/isucon/isucandar/failure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeErrorMessage(t *testing.T) {
+	cases := map[string]string{
+		`load: status-code: GET /posts/123 : expected(200) != actual(500)`:                    `load: status-code: GET /posts/<id> : expected(200) != actual(500)`,
+		`load: asset: GET /image/10484.png : context deadline exceeded`:                       `load: asset: GET /image/<id>.png : context deadline exceeded`,
+		`load: user: GET /@mary : not found`:                                                  `load: user: GET /@<user> : not found`,
+		`load: request: Get "http://localhost:8080/": dial tcp 127.0.0.1:8080: refused`:       `load: request: Get "http://localhost:<port>/": dial tcp <addr>: refused`,
+		`load: paging: GET /posts?max_created_at=2022-01-01T00:00:00Z : post 42 is not older`: `load: paging: GET /posts?<query> : post <id> is not older`,
+	}
+
+	for message, expected := range cases {
+		assert.Equal(t, expected, NormalizeErrorMessage(message))
+	}
+}
+
+func TestErrorSummary(t *testing.T) {
+	summary := NewErrorSummary(0)
+	summary.Add(errors.New("GET /posts/1 : expected(200) != actual(500)"))
+	summary.Add(errors.New("POST /login : connection refused"))
+	summary.Add(errors.New("GET /posts/2 : expected(200) != actual(500)"))
+	summary.Add(errors.New("GET /posts/3 : expected(200) != actual(500)"))
+
+	assert.Equal(t, []string{
+		"GET /posts/<id> : expected(200) != actual(500) (x3)",
+		"POST /login : connection refused",
+	}, summary.Lines())
+}
+
+func TestErrorSummaryMaxPerStep(t *testing.T) {
+	load := failure.StringCode("load")
+	validation := failure.StringCode("validation")
+
+	summary := NewErrorSummary(1)
+	summary.Add(failure.NewError(load, errors.New("GET /posts/1 : expected(200) != actual(500)")))
+	summary.Add(failure.NewError(load, errors.New("POST /login : connection refused")))
+	summary.Add(failure.NewError(load, errors.New("POST /login : connection refused")))
+	summary.Add(failure.NewError(validation, errors.New("GET / : timeout")))
+
+	assert.Equal(t, []string{
+		"load: GET /posts/<id> : expected(200) != actual(500)",
+		"validation: GET / : timeout",
+		"load: 2 more errors omitted",
+	}, summary.Lines())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/isucon/isucandar"
+	"github.com/isucon/isucandar/failure"
+)
+
+// 実装されているかがアプリケーションによって異なる機能を調べる関数の型
+// 機能が見つからなければ false を返してエラーにはしない
+// 見つかれば挙動を検証し、誤りがあれば step にエラーを追加して true を返す
+type FeatureProbe func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) bool
+
+type optionalFeature struct {
+	name  string
+	probe FeatureProbe
+}
+
+// 登録順に調べる任意の機能
+var optionalFeatures = []optionalFeature{}
+
+// 任意の機能を調べる関数を登録
+// 各機能は init で自身を登録する
+func RegisterOptionalFeature(name string, probe FeatureProbe) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, feature := range optionalFeatures {
+		if feature.name == name {
+			panic(fmt.Sprintf("optional feature %s is already registered", name))
+		}
+	}
+
+	optionalFeatures = append(optionalFeatures, optionalFeature{name: name, probe: probe})
+}
+
+func init() {
+	RegisterOptionalFeature("last-login", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) bool {
+		return s.ProbeLastLogin(ctx, step)
+	})
+}
+
+// 登録されたすべての任意の機能を調べ、見つかったかを大会運営向けロガーに出力
+func (s *Scenario) ProbeOptionalFeatures(ctx context.Context, step *isucandar.BenchmarkStep) {
+	registryMu.RLock()
+	features := append([]optionalFeature{}, optionalFeatures...)
+	registryMu.RUnlock()
+
+	for _, feature := range features {
+		if feature.probe(ctx, step, s) {
+			AdminLogger.Printf("optional feature: %s: detected", feature.name)
+		} else {
+			AdminLogger.Printf("optional feature: %s: not detected, skipped", feature.name)
+		}
+	}
+}
+
+// 最終ログイン日時の表示が変化するまでに待つ時間
+// 秒単位でしか表示されなくても 2 回のログインの間で変化するようにする
+const LastLoginResolution = 1100 * time.Millisecond
+
+// ユーザーページに最終ログイン日時が表示されていれば、ログインのたびに更新されることを検証する
+func (s *Scenario) ProbeLastLogin(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ユーザーの CSRF トークンを上書きしないように複製して使う
+	active := s.randomActiveUser()
+	if active == nil {
+		AdminLogger.Printf("last-login: no active user, skipped")
+		return true
+	}
+	user := &User{ID: active.ID, AccountName: active.AccountName, Password: active.Password}
+
+	before, foundBefore, ok := s.fetchLastLogin(ctx, step, user)
+	if !ok {
+		return false
+	}
+
+	select {
+	case <-ctx.Done():
+		return foundBefore
+	case <-time.After(LastLoginResolution):
+	}
+
+	after, foundAfter, ok := s.fetchLastLogin(ctx, step, user)
+	if !ok {
+		return foundBefore
+	}
+
+	if !foundBefore && !foundAfter {
+		return false
+	}
+
+	if !foundAfter {
+		step.AddError(failure.NewError(
+			ErrStaleLastLogin,
+			fmt.Errorf("GET /@%s : last login disappeared after login", user.AccountName),
+		))
+		return true
+	}
+
+	// 初回の表示がなければ、ログイン後に表示されたことで更新されたとみなす
+	if foundBefore && !after.After(before) {
+		step.AddError(failure.NewError(
+			ErrStaleLastLogin,
+			fmt.Errorf("GET /@%s : last login is not updated after login: %s -> %s", user.AccountName, before.Format(time.RFC3339), after.Format(time.RFC3339)),
+		))
+	}
+
+	return true
+}
+
+// 新しいユーザーエージェントでログインし、ユーザーページに表示された最終ログイン日時を取得
+func (s *Scenario) fetchLastLogin(ctx context.Context, step *isucandar.BenchmarkStep, user *User) (time.Time, bool, bool) {
+	ag, ok := s.loginWithNewAgent(ctx, step, user)
+	if !ok {
+		return time.Time{}, false, false
+	}
+
+	res, err := GetUserPageAction(ctx, ag, user.AccountName)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return time.Time{}, false, false
+	}
+	defer res.Body.Close()
+
+	lastLogin := time.Time{}
+	found := false
+	validation := ValidateResponse(
+		res,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 最終ログイン日時を取得
+		WithLastLogin(&lastLogin, &found),
+	)
+	validation.Add(step)
+
+	if !validation.IsEmpty() {
+		return time.Time{}, false, false
+	}
+
+	return lastLogin, found, true
+}
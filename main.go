@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"runtime/pprof"
+	"strings"
 	"time"
 
 	"github.com/isucon/isucandar"
 	"github.com/isucon/isucandar/failure"
+	"github.com/isucon/isucandar/score"
 )
 
 var (
@@ -23,7 +28,26 @@ const (
 	DefaultTargetHost               = "localhost:8080"
 	DefaultRequestTimeout           = 3 * time.Second
 	DefaultInitializeRequestTimeout = 10 * time.Second
+	DefaultInitializeSettleTimeout  = 10 * time.Second
 	DefaultExitErrorOnFail          = true
+	DefaultSeed                     = 1
+	DefaultCommentLimit             = 3
+	// agent.DefaultTransport と同じ値
+	DefaultMaxIdleConnsPerHost = 10000
+	DefaultUserDistribution    = UserDistributionUniform
+	DefaultUserZipfS           = 1.1
+	DefaultAutoscaleErrorRate  = 0.01
+	DefaultSlowest             = 10
+	DefaultFormat              = FormatText
+	DefaultMaxErrors           = 20
+)
+
+// 終了コード
+const (
+	// fail したとき
+	ExitCodeFail = 1
+	// initialize がタイムアウトしたとき
+	ExitCodeInitializeTimeout = 2
 )
 
 func init() {
@@ -38,20 +62,208 @@ func main() {
 	flag.StringVar(&option.TargetHost, "target-host", DefaultTargetHost, "Benchmark target host with port")
 	flag.DurationVar(&option.RequestTimeout, "request-timeout", DefaultRequestTimeout, "Default request timeout")
 	flag.DurationVar(&option.InitializeRequestTimeout, "initialize-request-timeout", DefaultInitializeRequestTimeout, "Initialize request timeout")
+	flag.DurationVar(&option.InitializeSettleTimeout, "initialize-settle-timeout", DefaultInitializeSettleTimeout, "Max time to wait for GET / to return 200 after initialize")
 	flag.BoolVar(&option.ExitErrorOnFail, "exit-error-on-fail", DefaultExitErrorOnFail, "Exit with error if benchmark fails")
+	flag.Int64Var(&option.Seed, "seed", DefaultSeed, "Random seed for generated data")
+	flag.StringVar(&option.CPUProfile, "cpuprofile", "", "Write CPU profile of the benchmarker to file")
+	flag.IntVar(&option.CommentLimit, "comment-limit", DefaultCommentLimit, "Expected number of comments shown per post on the index")
+	flag.BoolVar(&option.RequestID, "request-id", false, "Send unique X-Request-Id header with each request")
+	flag.StringVar(&option.RecordFile, "record", "", "Record all requests to file as JSON lines for -replay")
+	flag.StringVar(&option.ReplayFile, "replay", "", "Replay requests recorded by -record in order instead of running benchmark")
+	flag.BoolVar(&option.OneLine, "oneline", false, "Print machine readable single line result at the end")
+	flag.IntVar(&option.MaxIdleConnsPerHost, "max-idle-conns", DefaultMaxIdleConnsPerHost, "Max idle connections per host kept by each agent's transport")
+	flag.StringVar(&option.Resolve, "resolve", "", "Connect to ip instead of resolving host, keeping Host header (host:ip, like curl --resolve)")
+	flag.StringVar(&option.UserDistribution, "user-distribution", DefaultUserDistribution, "Distribution of users picked by load scenarios (uniform or zipf)")
+	flag.Float64Var(&option.UserZipfS, "user-zipf-s", DefaultUserZipfS, "Exponent (> 1) of zipf user distribution, larger is more skewed to hot users")
+	flag.StringVar(&option.ResultFile, "result-json", "", "Write benchmark result to file as JSON for -baseline")
+	flag.StringVar(&option.ResultFile, "result-file", "", "Deprecated: use -result-json instead. Same as -result-json")
+	flag.IntVar(&option.MaxErrors, "max-errors", DefaultMaxErrors, "Max distinct error messages shown to contestants per step, the rest are counted (0 means unlimited). All errors still count for the score and go to admin log")
+	flag.StringVar(&option.Format, "format", DefaultFormat, "Output format of the result at the end (text or json). With json, the result is written to stdout as JSON and logs go to stderr")
+	flag.StringVar(&option.BaselineFile, "baseline", "", "Compare result with previous result written by -result-json")
+	flag.BoolVar(&option.FailOnRegression, "fail-on-regression", false, "Exit with error if score is lower than -baseline")
+	flag.IntVar(&option.MaxInflight, "max-inflight", 0, "Max in-flight requests across all workers, excess requests wait for a free slot (0 means unlimited)")
+	flag.BoolVar(&option.Autoscale, "autoscale", false, "(experimental) Start workers at low parallelism and double it while error rate and latency stay low")
+	flag.Float64Var(&option.AutoscaleErrorRate, "autoscale-error-rate", DefaultAutoscaleErrorRate, "Max error rate per request regarded as sustainable by -autoscale")
+	flag.BoolVar(&option.ReadOnly, "read-only", false, "Run only scenarios with GET requests and skip validations mutating data. Scores of write tags (POST /login, POST /, POST /comment) are always 0 in this mode")
+	flag.IntVar(&option.Slowest, "slowest", DefaultSlowest, "Number of slowest requests reported to admin log (0 disables)")
+	flag.BoolVar(&option.ScoreOnly, "score-only", false, "Skip HTML content validations and check only status codes to push more load. Scores in this mode are not validated")
+	flag.BoolVar(&option.FetchImages, "fetch-images", true, "Download image bodies. If false, images are requested with HEAD and only the status code is checked, which saves bandwidth to focus load on dynamic pages but skips image integrity checks and requires the app to answer HEAD")
+	flag.BoolVar(&option.LiveStats, "live-stats", false, fmt.Sprintf("Print RPS over the last %s to admin log every %s during the run", LiveStatsWindow, LiveStatsInterval))
+	flag.StringVar(&option.ReuseAccountsFile, "reuse-accounts", "", "Save accounts registered by scenarios to file and log in with them on next runs instead of registering. Accounts that cannot log in are registered again")
+	flag.Var(&option.Headers, "header", "Extra header sent with every request as \"Name: Value\" (repeatable)")
+	scenarioTimeouts := flag.String("scenario-timeouts", "", "Request timeouts overriding -request-timeout per scenario (e.g. post-image=5s,ordered-index=1s)")
+	loadMix := flag.String("load-mix", "", "Relative weights of scenarios run by each worker (e.g. ordered-index=70,post-image=30). Only scenarios looping through the load can be mixed. Overrides -scenarios")
+	scenarios := flag.String("scenarios", "", fmt.Sprintf("Comma separated scenario names to run (default all: %s)", strings.Join(ScenarioNames(), ",")))
 
 	// コマンドライン引数のパースを実行
 	// この時点で各フィールドに値が設定されます
 	flag.Parse()
 
+	// 表示するエラーの上限は負にならない
+	if option.MaxErrors < 0 {
+		AdminLogger.Fatalf("-max-errors must not be negative: %d", option.MaxErrors)
+	}
+
+	// 表示されるコメントの件数は負にならない
+	if option.CommentLimit < 0 {
+		AdminLogger.Fatalf("-comment-limit must not be negative: %d", option.CommentLimit)
+	}
+
+	// 結果の出力形式を検証
+	switch option.Format {
+	case FormatText:
+	case FormatJSON:
+		// 標準出力には JSON だけを出力する
+		if option.OneLine {
+			AdminLogger.Fatal("-oneline cannot be used with -format=json")
+		}
+		ContestantLogger.SetOutput(os.Stderr)
+	default:
+		AdminLogger.Fatalf("unknown format: %s", option.Format)
+	}
+
+	// 実行するシナリオを選択
+	// 未登録のシナリオ名が指定されたらエラーで終了
+	names := []string{}
+	if *scenarios != "" {
+		names = strings.Split(*scenarios, ",")
+	}
+	// -read-only ならデータを変更しないシナリオだけを選択
+	selectScenarios := SelectScenarios
+	if option.ReadOnly {
+		selectScenarios = SelectReadOnlyScenarios
+	}
+	selected, err := selectScenarios(names)
+	if err != nil {
+		AdminLogger.Fatal(err)
+	}
+	option.Scenarios = selected
+
+	// シナリオの混合比率をパース
+	option.LoadMix, err = ParseLoadMix(*loadMix)
+	if err != nil {
+		AdminLogger.Fatal(err)
+	}
+	if option.ReadOnly {
+		for _, entry := range option.LoadMix {
+			if !IsReadOnlyScenario(entry.Name) {
+				AdminLogger.Fatalf("scenario %s is not read-only", entry.Name)
+			}
+		}
+	}
+
+	// シナリオごとのタイムアウトをパース
+	option.ScenarioTimeouts, err = ParseScenarioTimeouts(*scenarioTimeouts)
+	if err != nil {
+		AdminLogger.Fatal(err)
+	}
+
+	// 名前解決の上書き設定を検証
+	if option.Resolve != "" {
+		if _, _, err := ParseResolve(option.Resolve); err != nil {
+			AdminLogger.Fatal(err)
+		}
+	}
+
+	// ユーザーを選ぶ分布の設定を検証
+	if _, err := NewUserPicker(option.UserDistribution, option.UserZipfS, 0, option.Seed); err != nil {
+		AdminLogger.Fatal(err)
+	}
+
+	// 比較対象の結果は実行前にロードしておき、ファイルの不備で負荷走行が無駄にならないようにする
+	var baseline *Result
+	if option.BaselineFile != "" {
+		baseline, err = LoadResult(option.BaselineFile)
+		if err != nil {
+			AdminLogger.Fatal(err)
+		}
+	}
+
 	// 現在の設定を大会運営向けロガーに出力
 	AdminLogger.Print(option)
 
+	// すべてのリクエストに付与するヘッダを大会運営向けロガーに出力
+	for _, header := range option.Headers {
+		AdminLogger.Printf("header: %s", header.Redacted())
+	}
+
+	// 実行するシナリオの実際のタイムアウトを大会運営向けロガーに出力
+	for _, name := range option.Scenarios {
+		AdminLogger.Printf("timeout: scenario %s: %s", name, option.ScenarioTimeouts.Get(name, option.RequestTimeout))
+	}
+
+	// 生成するデータがシードから決まるように乱数を初期化
+	rand.Seed(option.Seed)
+
+	// ベンチマーカー自身の CPU プロファイルを取得
+	// os.Exit では defer が実行されないので終了前に必ず stopProfile を呼ぶ
+	stopProfile := func() {}
+	if option.CPUProfile != "" {
+		f, err := os.Create(option.CPUProfile)
+		if err != nil {
+			AdminLogger.Fatal(err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			AdminLogger.Fatal(err)
+		}
+		stopProfile = func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		}
+	}
+	defer stopProfile()
+
+	// 全ワーカーで共有する同時実行数の上限を設定
+	inflightLimiter = NewInflightLimiter(option.MaxInflight)
+
+	// 指定されていれば内容の検証を省略
+	scoreOnly = option.ScoreOnly
+
+	// 遅かったリクエストを記録
+	if option.Slowest > 0 {
+		telemetry.TrackSlowest(option.Slowest)
+	}
+
+	// 送信したリクエストをすべてファイルに記録
+	// 1件ずつファイルに直接書き込むので os.Exit で終了しても記録は失われない
+	if option.RecordFile != "" {
+		recorder, err = NewRecorder(option.RecordFile)
+		if err != nil {
+			AdminLogger.Fatal(err)
+		}
+		defer recorder.Close()
+	}
+
+	// 記録されたリクエストを再生するだけで、ベンチマークは実行しない
+	if option.ReplayFile != "" {
+		records, err := LoadRecordedRequests(option.ReplayFile)
+		if err != nil {
+			AdminLogger.Fatal(err)
+		}
+		if err := Replay(context.Background(), option, records); err != nil {
+			AdminLogger.Fatal(err)
+		}
+		return
+	}
+
 	// シナリオの生成
 	scenario := &Scenario{
 		Option: option,
 	}
 
+	// 平均レイテンシがタイムアウトの半分を超えたら、並列数を増やしてもタイムアウトが増えるだけとみなす
+	if option.Autoscale {
+		scenario.Autoscaler = NewAutoscaler(option.AutoscaleErrorRate, option.RequestTimeout/2)
+	}
+
+	// 以前の実行で登録したアカウントを読み込む
+	if option.ReuseAccountsFile != "" {
+		scenario.Accounts, err = LoadAccountStore(option.ReuseAccountsFile)
+		if err != nil {
+			AdminLogger.Fatal(err)
+		}
+	}
+
 	// ベンチマークの生成
 	benchmark, err := isucandar.NewBenchmark(
 		// isucandar.Benchmark はステップ内の panic を自動で recover する機能があるが、今回は利用しない
@@ -70,16 +282,60 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// 指定されていれば実行中に直近の RPS を表示
+	stopLiveStats := func() {}
+	if option.LiveStats {
+		telemetry.TrackLiveStats(LiveStatsWindow)
+		liveCtx, cancelLive := context.WithCancel(ctx)
+		stopLiveStats = cancelLive
+		go telemetry.RunLiveStats(liveCtx, LiveStatsInterval)
+	}
+
 	// ベンチマーク開始
+	startedAt := time.Now()
 	result := benchmark.Start(ctx)
+	timing := Timing{
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		Load:       scenario.LoadDuration(),
+	}
+	stopLiveStats()
+
+	// 次の実行で再利用するアカウントを保存
+	if scenario.Accounts != nil {
+		if err := scenario.Accounts.Save(); err != nil {
+			AdminLogger.Print(err)
+		}
+	}
 
 	// エラーをすべて表示
+	errorSummary := NewErrorSummary(option.MaxErrors)
 	for _, err := range result.Errors.All() {
-		// 選手向けにエラーメッセージが表示される
-		ContestantLogger.Printf("%v", err)
+		// 選手向けには似たエラーメッセージをまとめて件数とともに、ステップごとに上限までだけ表示する
+		errorSummary.Add(err)
 		// 大会運営向けにスタックトレース付きエラーメッセージが表示される
 		AdminLogger.Printf("%+v", err)
 	}
+	for _, line := range errorSummary.Lines() {
+		ContestantLogger.Print(line)
+	}
+
+	current := NewResult(result, timing)
+
+	// initialize がタイムアウトしたら負荷走行は行われていないので、スコアを出さずに中断
+	for _, err := range result.Errors.All() {
+		if failure.IsCode(err, ErrInitializeTimeout) {
+			ContestantLogger.Printf("initialize timed out after %s", option.InitializeRequestTimeout)
+			current.Score = 0
+			current.Passed = false
+			writeResult(current, option)
+			if option.OneLine {
+				fmt.Printf("RESULT score=0 pass=false errors=%d\n", current.Errors)
+			}
+			stopProfile()
+			os.Exit(ExitCodeInitializeTimeout)
+		}
+	}
 
 	// スコアをすべて表示
 	for tag, count := range result.Score.Breakdown() {
@@ -88,34 +344,98 @@ func main() {
 	ContestantLogger.Printf("error: %d", len(result.Errors.All()))
 
 	// スコアの表示
-	score := SumScore(result)
-	ContestantLogger.Printf("score: %d", score)
+	ContestantLogger.Printf("score: %d", current.Score)
+	if option.ScoreOnly {
+		// 検証済みのスコアと取り違えないように明示する
+		ContestantLogger.Printf("warning: -score-only: content validation was skipped, score is not validated")
+	}
+
+	// ベンチマーカー自身の計測値を大会運営向けに表示
+	telemetry.Print()
+	if scenario.Autoscaler != nil {
+		scenario.Autoscaler.Print()
+	}
+
+	// 結果をファイルに保存
+	writeResult(current, option)
+
+	// 以前の結果との差分を表示
+	regressed := false
+	if baseline != nil {
+		for _, line := range current.Compare(baseline) {
+			ContestantLogger.Printf("baseline: %s", line)
+		}
+		regressed = current.Regressed(baseline)
+	}
+
+	// ポータルなどから簡単にパースできる1行の結果を表示
+	// 末尾の行をパースすればよいように必ず最後に出力する
+	if option.OneLine {
+		fmt.Printf("RESULT score=%d pass=%v errors=%d\n", current.Score, current.Passed, current.Errors)
+	}
+
+	// fail ならエラーで終了
+	if option.ExitErrorOnFail && !current.Passed {
+		stopProfile()
+		os.Exit(ExitCodeFail)
+	}
 
-	// 0点以下(fail)ならエラーで終了
-	if option.ExitErrorOnFail && score <= 0 {
-		os.Exit(1)
+	// 指定されていれば以前の結果よりスコアが下がったときもエラーで終了
+	if option.FailOnRegression && regressed {
+		stopProfile()
+		os.Exit(ExitCodeFail)
 	}
 }
 
-func SumScore(result *isucandar.BenchmarkResult) int64 {
-	score := result.Score
-	// 各タグに倍率を設定
-	score.Set(ScoreGETRoot, 1)
-	score.Set(ScoreGETLogin, 1)
-	score.Set(ScorePOSTLogin, 2)
-	score.Set(ScorePOSTRoot, 5)
+// 結果を -result-json のファイルと、-format=json なら標準出力に書き出す
+func writeResult(current *Result, option Option) {
+	if option.ResultFile != "" {
+		if err := current.Save(option.ResultFile); err != nil {
+			AdminLogger.Print(err)
+		}
+	}
+	if option.Format == FormatJSON {
+		if err := current.Write(os.Stdout); err != nil {
+			AdminLogger.Print(err)
+		}
+	}
+}
 
-	// 加点分の合算
-	addition := score.Sum()
+// 各タグの倍率
+var ScoreMultipliers = map[score.ScoreTag]int64{
+	ScoreGETRoot:     1,
+	ScoreGETLogin:    1,
+	ScorePOSTLogin:   2,
+	ScorePOSTRoot:    5,
+	ScorePOSTComment: 2,
+	ScoreGETUserPage: 1,
+}
 
-	// エラーは1つ1点減点
-	deduction := len(result.Errors.All())
+func SumScore(result *isucandar.BenchmarkResult) int64 {
+	addition, deduction := scoreParts(result)
 
 	// 合計(0を下回ったら0点にする)
-	sum := addition - int64(deduction)
+	sum := addition - deduction
 	if sum < 0 {
 		sum = 0
 	}
 
 	return sum
 }
+
+// 加点と減点をそれぞれ返す
+func scoreParts(result *isucandar.BenchmarkResult) (int64, int64) {
+	score := result.Score
+	// 各タグに倍率を設定
+	for tag, multiplier := range ScoreMultipliers {
+		score.Set(tag, multiplier)
+	}
+
+	// 加点分の合算
+	addition := score.Sum()
+
+	// エラーは1つ1点減点
+	deduction := int64(len(result.Errors.All()))
+
+	return addition, deduction
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/isucon/isucandar/agent"
@@ -14,6 +15,36 @@ type Option struct {
 	RequestTimeout           time.Duration
 	InitializeRequestTimeout time.Duration
 	ExitErrorOnFail          bool
+	Seed                     int64
+	CPUProfile               string
+	CommentLimit             int
+	Scenarios                []string
+	RequestID                bool
+	LoadMix                  LoadMix
+	RecordFile               string
+	ReplayFile               string
+	OneLine                  bool
+	MaxIdleConnsPerHost      int
+	InitializeSettleTimeout  time.Duration
+	Resolve                  string
+	UserDistribution         string
+	UserZipfS                float64
+	ResultFile               string
+	BaselineFile             string
+	FailOnRegression         bool
+	ScenarioTimeouts         ScenarioTimeouts
+	MaxInflight              int
+	Autoscale                bool
+	AutoscaleErrorRate       float64
+	ReadOnly                 bool
+	Slowest                  int
+	Headers                  Headers
+	ScoreOnly                bool
+	FetchImages              bool
+	LiveStats                bool
+	ReuseAccountsFile        string
+	Format                   string
+	MaxErrors                int
 }
 
 // fmt.Stringer インターフェースを実装
@@ -24,7 +55,37 @@ func (o Option) String() string {
 		fmt.Sprintf("--target-host=%s", o.TargetHost),
 		fmt.Sprintf("--request-timeout=%s", o.RequestTimeout.String()),
 		fmt.Sprintf("--initialize-request-timeout=%s", o.InitializeRequestTimeout.String()),
+		fmt.Sprintf("--initialize-settle-timeout=%s", o.InitializeSettleTimeout.String()),
 		fmt.Sprintf("--exit-error-on-fail=%v", o.ExitErrorOnFail),
+		fmt.Sprintf("--seed=%d", o.Seed),
+		fmt.Sprintf("--cpuprofile=%s", o.CPUProfile),
+		fmt.Sprintf("--comment-limit=%d", o.CommentLimit),
+		fmt.Sprintf("--scenarios=%s", strings.Join(o.Scenarios, ",")),
+		fmt.Sprintf("--request-id=%v", o.RequestID),
+		fmt.Sprintf("--load-mix=%s", o.LoadMix),
+		fmt.Sprintf("--record=%s", o.RecordFile),
+		fmt.Sprintf("--replay=%s", o.ReplayFile),
+		fmt.Sprintf("--oneline=%v", o.OneLine),
+		fmt.Sprintf("--max-idle-conns=%d", o.MaxIdleConnsPerHost),
+		fmt.Sprintf("--resolve=%s", o.Resolve),
+		fmt.Sprintf("--user-distribution=%s", o.UserDistribution),
+		fmt.Sprintf("--user-zipf-s=%v", o.UserZipfS),
+		fmt.Sprintf("--result-json=%s", o.ResultFile),
+		fmt.Sprintf("--format=%s", o.Format),
+		fmt.Sprintf("--max-errors=%d", o.MaxErrors),
+		fmt.Sprintf("--baseline=%s", o.BaselineFile),
+		fmt.Sprintf("--fail-on-regression=%v", o.FailOnRegression),
+		fmt.Sprintf("--scenario-timeouts=%s", o.ScenarioTimeouts),
+		fmt.Sprintf("--max-inflight=%d", o.MaxInflight),
+		fmt.Sprintf("--autoscale=%v", o.Autoscale),
+		fmt.Sprintf("--autoscale-error-rate=%v", o.AutoscaleErrorRate),
+		fmt.Sprintf("--read-only=%v", o.ReadOnly),
+		fmt.Sprintf("--slowest=%d", o.Slowest),
+		fmt.Sprintf("--header=%s", o.Headers),
+		fmt.Sprintf("--score-only=%v", o.ScoreOnly),
+		fmt.Sprintf("--fetch-images=%v", o.FetchImages),
+		fmt.Sprintf("--live-stats=%v", o.LiveStats),
+		fmt.Sprintf("--reuse-accounts=%s", o.ReuseAccountsFile),
 	}
 
 	return strings.Join(args, " ")
@@ -32,20 +93,54 @@ func (o Option) String() string {
 
 // Option の内容に沿った agent.Agent を生成
 func (o Option) NewAgent(forInitialize bool) (*agent.Agent, error) {
+	// agent.DefaultTransport を都度クローンして利用
+	transport := agent.DefaultTransport.Clone()
+	// 名前解決の上書きが指定されていれば接続先を差し替える
+	// Option.Resolve は main で検証済み
+	if o.Resolve != "" {
+		if host, ip, err := ParseResolve(o.Resolve); err == nil {
+			transport.DialContext = ResolveDialContext(host, ip, transport.DialContext)
+		}
+	}
+	// 確立したコネクション数を数えるために DialContext をラップ
+	transport.DialContext = telemetry.WrapDialContext(transport.DialContext)
+	// 並列数が高いときにコネクションを使い捨てないように保持するアイドルコネクション数
+	transport.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+
 	agentOptions := []agent.AgentOption{
 		// リクエストのベース URL は Option.TargetHost かつ HTTP
 		agent.WithBaseURL(fmt.Sprintf("http://%s/", o.TargetHost)),
-		// agent.DefaultTransport を都度クローンして利用
-		agent.WithCloneTransport(agent.DefaultTransport),
+		agent.WithTransport(transport),
 	}
 
 	// initialize 用の agent.Agent かによってタイムアウト時間が違うのでオプションを調整
 	if forInitialize {
 		agentOptions = append(agentOptions, agent.WithTimeout(o.InitializeRequestTimeout))
 	} else {
-		agentOptions = append(agentOptions, agent.WithTimeout(o.RequestTimeout))
+		// シナリオごとのタイムアウトは Transport で context に設定するので、
+		// http.Client のタイムアウトはそれより先に切れないように最大値にしておく
+		agentOptions = append(agentOptions, agent.WithTimeout(o.ScenarioTimeouts.Max(o.RequestTimeout)))
 	}
 
 	// オプションに従って agent.Agent を生成
-	return agent.NewAgent(agentOptions...)
+	ag, err := agent.NewAgent(agentOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// -fetch-images=false の画像は HEAD で送るので、ボディのないレスポンスが GET のキャッシュとして使われないようにする
+	if !o.FetchImages && ag.CacheStore != nil {
+		ag.CacheStore = &imageUncachedStore{CacheStore: ag.CacheStore}
+	}
+
+	// 送信するすべてのリクエストを仲介するために Transport をラップ
+	ag.HttpClient.Transport = &Transport{
+		option:    o,
+		transport: transport,
+		agentID:   atomic.AddInt64(&agentSequence, 1),
+		// initialize 用の agent.Agent はシナリオごとのタイムアウトの対象外
+		scenarioTimeout: !forInitialize && len(o.ScenarioTimeouts) > 0,
+	}
+
+	return ag, nil
 }
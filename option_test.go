@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionNewAgentMaxIdleConnsPerHost(t *testing.T) {
+	option := Option{
+		TargetHost:          "localhost:8080",
+		MaxIdleConnsPerHost: 42,
+	}
+
+	ag, err := option.NewAgent(false)
+	assert.NoError(t, err)
+
+	transport, ok := ag.HttpClient.Transport.(*Transport)
+	assert.True(t, ok)
+	assert.Equal(t, 42, transport.transport.MaxIdleConnsPerHost)
+}
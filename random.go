@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"math/rand"
+	"sync"
 )
 
 func randomColor() color.RGBA {
@@ -55,3 +57,136 @@ func randomText() string {
 
 	return prefix + ", " + suffix
 }
+
+var (
+	// マルチバイト文字や記号を含むアカウント名・パスワードに使う文字集合
+	randomMultibyteRunes = []rune("あいうえおかきくけこアイウエオカキクケコ漢字表記椅子今日は")
+	randomSymbolRunes    = []rune("!#$%&*+-.=?@^_~")
+)
+
+// マルチバイト文字を含むランダムな文字列を r から生成
+// シードから生成した r を渡せば実行ごとに同じ文字列になる
+func randomMultibyteString(r *rand.Rand, length int) string {
+	return randomStringFrom(r, randomMultibyteRunes, length)
+}
+
+// 記号を含むランダムな文字列を r から生成
+func randomSymbolString(r *rand.Rand, length int) string {
+	return randomStringFrom(r, randomSymbolRunes, length)
+}
+
+// 文字集合から r で選んだ文字を並べた文字列を生成
+// r が nil ならグローバルな乱数を使う
+func randomStringFrom(r *rand.Rand, set []rune, length int) string {
+	intn := rand.Intn
+	if r != nil {
+		intn = r.Intn
+	}
+
+	runes := make([]rune, length)
+	for i := range runes {
+		runes[i] = set[intn(len(set))]
+	}
+
+	return string(runes)
+}
+
+var (
+	// アプリケーションが受け付けるアカウント名・パスワードに使える文字集合
+	randomAccountRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_")
+)
+
+// アプリケーションが受け付けるランダムな文字列を生成
+// rand はシードから生成されるので実行ごとに同じ文字列になる
+func randomAccountString(length int) string {
+	runes := make([]rune, length)
+	for i := range runes {
+		runes[i] = randomAccountRunes[rand.Intn(len(randomAccountRunes))]
+	}
+
+	return string(runes)
+}
+
+// 新規登録するユーザーを生成
+func randomNewUser() *User {
+	return &User{
+		AccountName: "isu_" + randomAccountString(8),
+		Password:    randomAccountString(12),
+	}
+}
+
+// 負荷走行でユーザーを選ぶ分布
+const (
+	// すべてのユーザーが同じ確率で選ばれる
+	UserDistributionUniform = "uniform"
+	// 一部のユーザーに偏って選ばれる
+	UserDistributionZipf = "zipf"
+)
+
+// 分布に従ってユーザーの添字を選ぶ構造体
+// 同じユーザーを複数のワーカーが同時に使うとセッションが混ざるので、使用中のユーザーも管理する
+type UserPicker struct {
+	mu   sync.Mutex
+	n    int
+	zipf *rand.Zipf
+	busy map[int]struct{}
+}
+
+// 分布に従ってユーザーの添字を選ぶ UserPicker を生成
+// zipf 分布の場合 s (> 1) が大きいほど少数のユーザーに偏る
+func NewUserPicker(distribution string, s float64, n int, seed int64) (*UserPicker, error) {
+	picker := &UserPicker{n: n, busy: map[int]struct{}{}}
+
+	switch distribution {
+	case UserDistributionUniform:
+	case UserDistributionZipf:
+		if s <= 1 {
+			return nil, fmt.Errorf("zipf exponent must be greater than 1: %v", s)
+		}
+		if n > 0 {
+			// rand.Zipf はスレッドセーフではないので UserPicker.mu で保護する
+			picker.zipf = rand.NewZipf(rand.New(rand.NewSource(seed)), s, 1, uint64(n-1))
+		}
+	default:
+		return nil, fmt.Errorf("unknown user distribution: %s", distribution)
+	}
+
+	return picker, nil
+}
+
+// 分布に従ってユーザーの添字を返す
+func (p *UserPicker) Index() int {
+	if p.n <= 0 {
+		return 0
+	}
+
+	if p.zipf == nil {
+		return rand.Intn(p.n)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return int(p.zipf.Uint64())
+}
+
+// ユーザーを使用中にする。すでに使用中なら false を返す
+func (p *UserPicker) Acquire(userID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.busy[userID]; ok {
+		return false
+	}
+	p.busy[userID] = struct{}{}
+
+	return true
+}
+
+// 使用中のユーザーを解放する
+func (p *UserPicker) Release(userID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.busy, userID)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+// 記録されたリクエスト 1 件分
+// -record で 1 行 1 件の JSON として書き出され、-replay で読み込まれる
+type RecordedRequest struct {
+	// リクエストを送信した agent.Agent の連番
+	// 再生時には同じ連番のリクエストを同じ agent.Agent (同じ Cookie) で送信する
+	Agent       int64     `json:"agent"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	SentAt      time.Time `json:"sent_at"`
+}
+
+// 送信したリクエストをファイルに記録する構造体
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// リクエストの記録先
+// -record が指定されていなければ nil のまま
+var recorder *Recorder
+
+// 記録先のファイルを作成して Recorder を生成
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
+}
+
+// リクエストを記録
+// リクエストのボディは GetBody で複製して読むので送信には影響しない
+func (r *Recorder) Record(agentID int64, req *http.Request) {
+	if r == nil {
+		return
+	}
+
+	record := &RecordedRequest{
+		Agent:       agentID,
+		RequestID:   req.Header.Get(RequestIDHeader),
+		Method:      req.Method,
+		Path:        req.URL.RequestURI(),
+		ContentType: req.Header.Get("Content-Type"),
+		SentAt:      time.Now(),
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			record.Body, _ = ioutil.ReadAll(body)
+			body.Close()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.encoder.Encode(record); err != nil {
+		AdminLogger.Printf("record: %v", err)
+	}
+}
+
+// 記録先のファイルを閉じる
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+// 記録されたリクエストを読み込む
+func LoadRecordedRequests(path string) ([]*RecordedRequest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []*RecordedRequest{}
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		record := &RecordedRequest{}
+		if err := decoder.Decode(record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// 記録されたリクエストを記録順にそのまま再生
+// 再生したリクエストには元のリクエスト ID から作った X-Request-Id を付与する
+// CSRF トークンなどセッションに依存する値も記録時のまま送信されることに注意
+func Replay(ctx context.Context, o Option, records []*RecordedRequest) error {
+	agents := map[int64]*agent.Agent{}
+
+	for i, record := range records {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		// 記録時と同じ agent.Agent の単位で Cookie を引き継ぐ
+		ag, ok := agents[record.Agent]
+		if !ok {
+			var err error
+			ag, err = o.NewAgent(record.Path == "/initialize")
+			if err != nil {
+				return err
+			}
+			agents[record.Agent] = ag
+		}
+
+		req, err := ag.NewRequest(record.Method, record.Path, bytes.NewReader(record.Body))
+		if err != nil {
+			return err
+		}
+		if record.ContentType != "" {
+			req.Header.Set("Content-Type", record.ContentType)
+		}
+
+		requestID := fmt.Sprintf("replay-%d", i+1)
+		if record.RequestID != "" {
+			requestID = "replay-" + record.RequestID
+		}
+		req.Header.Set(RequestIDHeader, requestID)
+
+		res, err := ag.Do(ctx, req)
+		if err != nil {
+			AdminLogger.Printf("replay: request-id=%s: %s %s : %v", requestID, record.Method, record.Path, err)
+			continue
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+
+		AdminLogger.Printf("replay: request-id=%s: %s %s : %d", requestID, record.Method, record.Path, res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isucon/isucandar"
+	"github.com/isucon/isucandar/worker"
+)
+
+// LoadMix に従って実行するワーカーの並列数
+const LoadMixParallelism = 8
+
+// 負荷走行の間ずっと繰り返すシナリオの繰り返し回数
+const InfinityLoop int32 = -1
+
+// 負荷走行で繰り返し実行されるシナリオ 1 回分の処理を表す関数の型
+type ScenarioFunc func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario)
+
+var (
+	registryMu sync.RWMutex
+	// シナリオ名からシナリオを引くためのレジストリ
+	scenarioRegistry = map[string]ScenarioFunc{}
+	// シナリオ名からシナリオを実行するワーカーのオプションを引くためのレジストリ
+	scenarioWorkerOptions = map[string][]worker.WorkerOption{}
+	// データを変更しない GET だけのシナリオ名の集合
+	readOnlyScenarios = map[string]bool{}
+	// シナリオ名からシナリオの繰り返し回数を引くためのレジストリ
+	scenarioLoopCounts = map[string]int32{}
+)
+
+// シナリオをレジストリに登録
+// 各シナリオは init で自身を登録する
+// loopCount 回繰り返し、InfinityLoop なら負荷走行の間ずっと繰り返す
+func RegisterScenario(name string, f ScenarioFunc, loopCount int32, opts ...worker.WorkerOption) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := scenarioRegistry[name]; ok {
+		panic(fmt.Sprintf("scenario %s is already registered", name))
+	}
+
+	loop := worker.WithLoopCount(loopCount)
+	if loopCount == InfinityLoop {
+		loop = worker.WithInfinityLoop()
+	}
+
+	scenarioRegistry[name] = f
+	scenarioWorkerOptions[name] = append([]worker.WorkerOption{loop}, opts...)
+	scenarioLoopCounts[name] = loopCount
+}
+
+// GET だけでデータを変更しないシナリオとしてレジストリに登録
+// -read-only ではこの関数で登録されたシナリオだけが実行される
+func RegisterReadOnlyScenario(name string, f ScenarioFunc, loopCount int32, opts ...worker.WorkerOption) {
+	RegisterScenario(name, f, loopCount, opts...)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	readOnlyScenarios[name] = true
+}
+
+// データを変更しないシナリオかを判定
+func IsReadOnlyScenario(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return readOnlyScenarios[name]
+}
+
+// 負荷走行の間ずっと繰り返すシナリオかを判定
+func IsInfinityLoopScenario(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return scenarioLoopCounts[name] == InfinityLoop
+}
+
+// 登録されているシナリオ名を昇順で返す
+func ScenarioNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(scenarioRegistry))
+	for name := range scenarioRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// 実行するシナリオ名を検証して返す
+// 空ならすべてのシナリオを実行し、未登録の名前が含まれていればエラーを返す
+func SelectScenarios(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return ScenarioNames(), nil
+	}
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	selected := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := scenarioRegistry[name]; !ok {
+			return nil, fmt.Errorf("unknown scenario: %s", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		selected = append(selected, name)
+	}
+
+	return selected, nil
+}
+
+// -read-only で実行するシナリオ名を検証して返す
+// 空ならデータを変更しないシナリオをすべて実行し、データを変更するシナリオが含まれていればエラーを返す
+func SelectReadOnlyScenarios(names []string) ([]string, error) {
+	selected, err := SelectScenarios(names)
+	if err != nil {
+		return nil, err
+	}
+
+	readOnly := []string{}
+	for _, name := range selected {
+		if IsReadOnlyScenario(name) {
+			readOnly = append(readOnly, name)
+		} else if len(names) > 0 {
+			return nil, fmt.Errorf("scenario %s is not read-only", name)
+		}
+	}
+
+	return readOnly, nil
+}
+
+// シナリオ名からシナリオを実行するワーカーを生成
+func NewScenarioWorker(name string, step *isucandar.BenchmarkStep, s *Scenario) (*worker.Worker, error) {
+	registryMu.RLock()
+	f, ok := scenarioRegistry[name]
+	opts := scenarioWorkerOptions[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown scenario: %s", name)
+	}
+
+	return worker.NewWorker(func(ctx context.Context, _ int) {
+		// シナリオごとの集計のために context にシナリオ名を持たせる
+		f(WithScenarioName(ctx, name), step, s)
+	}, opts...)
+}
+
+// context にシナリオ名を持たせるためのキー
+type scenarioNameKey struct{}
+
+// シナリオ名を持った context を返す
+func WithScenarioName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, scenarioNameKey{}, name)
+}
+
+// context からシナリオ名を取り出す
+// シナリオ外のリクエストなら空文字を返す
+func ScenarioNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(scenarioNameKey{}).(string)
+	return name
+}
+
+// 負荷走行でのシナリオの混合比率
+type LoadMix []LoadMixEntry
+
+// シナリオ名とその相対的な重み
+type LoadMixEntry struct {
+	Name   string
+	Weight int
+}
+
+// "name=weight,name=weight" 形式の文字列から LoadMix を生成
+// 未登録のシナリオ名、無限回繰り返さないシナリオ名や正でない重みが含まれていればエラーを返す
+func ParseLoadMix(value string) (LoadMix, error) {
+	mix := LoadMix{}
+	if strings.TrimSpace(value) == "" {
+		return mix, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid load mix entry: %s", pair)
+		}
+
+		name := strings.TrimSpace(kv[0])
+		if _, err := SelectScenarios([]string{name}); err != nil {
+			return nil, err
+		}
+		// 混合比率のワーカーは無限回繰り返すので、回数を決めて登録されたシナリオは選べない
+		if !IsInfinityLoopScenario(name) {
+			return nil, fmt.Errorf("scenario %s runs a fixed number of times and cannot be used in load mix", name)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid load mix weight: %s", pair)
+		}
+
+		mix = append(mix, LoadMixEntry{Name: name, Weight: weight})
+	}
+
+	return mix, nil
+}
+
+// fmt.Stringer インターフェースを実装
+func (m LoadMix) String() string {
+	pairs := make([]string, 0, len(m))
+	for _, entry := range m {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", entry.Name, entry.Weight))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// 重みに従ってシナリオ名をランダムに選択
+func (m LoadMix) Pick() string {
+	total := 0
+	for _, entry := range m {
+		total += entry.Weight
+	}
+	if total <= 0 {
+		return ""
+	}
+
+	n := rand.Intn(total)
+	for _, entry := range m {
+		if n < entry.Weight {
+			return entry.Name
+		}
+		n -= entry.Weight
+	}
+
+	return ""
+}
+
+// LoadMix に従って毎回シナリオを選択して実行するワーカーを生成
+func NewLoadMixWorker(mix LoadMix, step *isucandar.BenchmarkStep, s *Scenario) (*worker.Worker, error) {
+	return worker.NewWorker(func(ctx context.Context, _ int) {
+		name := mix.Pick()
+
+		registryMu.RLock()
+		f, ok := scenarioRegistry[name]
+		registryMu.RUnlock()
+
+		if ok {
+			f(WithScenarioName(ctx, name), step, s)
+		}
+	},
+		// 無限回繰り返す
+		worker.WithInfinityLoop(),
+		// 既定のシナリオ構成の並列数の合計に合わせる
+		worker.WithMaxParallelism(LoadMixParallelism),
+	)
+}
+
+// シナリオごとのリクエストタイムアウト
+// 指定されていないシナリオは Option.RequestTimeout を使う
+type ScenarioTimeouts map[string]time.Duration
+
+// "name=duration,name=duration" 形式の文字列から ScenarioTimeouts を生成
+// 未登録のシナリオ名や正でない時間が含まれていればエラーを返す
+func ParseScenarioTimeouts(value string) (ScenarioTimeouts, error) {
+	timeouts := ScenarioTimeouts{}
+	if strings.TrimSpace(value) == "" {
+		return timeouts, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid scenario timeout entry: %s", pair)
+		}
+
+		name := strings.TrimSpace(kv[0])
+		if _, err := SelectScenarios([]string{name}); err != nil {
+			return nil, err
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid scenario timeout: %s", pair)
+		}
+
+		timeouts[name] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// fmt.Stringer インターフェースを実装
+func (t ScenarioTimeouts) String() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, t[name]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// シナリオのタイムアウトを返す。指定されていなければ fallback を返す
+func (t ScenarioTimeouts) Get(name string, fallback time.Duration) time.Duration {
+	if timeout, ok := t[name]; ok {
+		return timeout
+	}
+
+	return fallback
+}
+
+// 指定されたタイムアウトと fallback のうち最大のものを返す
+func (t ScenarioTimeouts) Max(fallback time.Duration) time.Duration {
+	max := fallback
+	for _, timeout := range t {
+		if timeout > max {
+			max = timeout
+		}
+	}
+
+	return max
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLoadMix(t *testing.T) {
+	mix, err := ParseLoadMix("ordered-index=70, post-image=30")
+	assert.NoError(t, err)
+	assert.Equal(t, LoadMix{
+		{Name: "ordered-index", Weight: 70},
+		{Name: "post-image", Weight: 30},
+	}, mix)
+	assert.Equal(t, "ordered-index=70,post-image=30", mix.String())
+
+	mix, err = ParseLoadMix("")
+	assert.NoError(t, err)
+	assert.Empty(t, mix)
+
+	_, err = ParseLoadMix("unknown=10")
+	assert.Error(t, err)
+
+	_, err = ParseLoadMix("register-empty=10")
+	assert.Error(t, err)
+
+	_, err = ParseLoadMix("ordered-index=0")
+	assert.Error(t, err)
+
+	_, err = ParseLoadMix("ordered-index")
+	assert.Error(t, err)
+}
+
+func TestLoadMixPick(t *testing.T) {
+	mix := LoadMix{
+		{Name: "ordered-index", Weight: 1},
+	}
+	assert.Equal(t, "ordered-index", mix.Pick())
+
+	assert.Equal(t, "", LoadMix{}.Pick())
+}
+
+func TestParseScenarioTimeouts(t *testing.T) {
+	timeouts, err := ParseScenarioTimeouts("post-image=5s, ordered-index=500ms")
+	assert.NoError(t, err)
+	assert.Equal(t, ScenarioTimeouts{
+		"post-image":    5 * time.Second,
+		"ordered-index": 500 * time.Millisecond,
+	}, timeouts)
+	assert.Equal(t, "ordered-index=500ms,post-image=5s", timeouts.String())
+	assert.Equal(t, 5*time.Second, timeouts.Get("post-image", time.Second))
+	assert.Equal(t, time.Second, timeouts.Get("comment-count", time.Second))
+	assert.Equal(t, 5*time.Second, timeouts.Max(time.Second))
+	assert.Equal(t, 10*time.Second, timeouts.Max(10*time.Second))
+
+	_, err = ParseScenarioTimeouts("unknown=1s")
+	assert.Error(t, err)
+
+	_, err = ParseScenarioTimeouts("post-image=0s")
+	assert.Error(t, err)
+
+	_, err = ParseScenarioTimeouts("post-image")
+	assert.Error(t, err)
+}
+
+func TestSelectReadOnlyScenarios(t *testing.T) {
+	selected, err := SelectReadOnlyScenarios(nil)
+	assert.NoError(t, err)
+	assert.Contains(t, selected, "ordered-index")
+	assert.NotContains(t, selected, "post-image")
+
+	selected, err = SelectReadOnlyScenarios([]string{"ordered-index"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ordered-index"}, selected)
+
+	_, err = SelectReadOnlyScenarios([]string{"ordered-index", "post-image"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isucon/isucandar"
+	"github.com/isucon/isucandar/failure"
+)
+
+// 最後に出力する結果の形式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// エラーの分類
+// 遅すぎたのか、誤っていたのか、ベンチマークを続けられなかったのかを区別する
+const (
+	ErrorCategoryTimeout    = "timeout"
+	ErrorCategoryValidation = "validation"
+	ErrorCategoryCritical   = "critical"
+)
+
+// 発生すると負荷走行が行えないか、結果が信頼できなくなるエラーコード
+var criticalErrorCodes = []failure.StringCode{
+	ErrInitializeTimeout,
+	ErrApplicationNotReady,
+	ErrFailedLoadJSON,
+	ErrCannotNewAgent,
+}
+
+// エラーを ErrorCategory のいずれかに分類
+func ClassifyError(err error) string {
+	for _, code := range criticalErrorCodes {
+		if failure.IsCode(err, code) {
+			return ErrorCategoryCritical
+		}
+	}
+
+	if failure.IsCode(err, failure.TimeoutErrorCode) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorCategoryTimeout
+	}
+	// 静的ファイルの取得エラーなどはメッセージとしてしか残らない
+	// failure.Error の Error() はエラーコードしか返さないので、ラップしたエラーごと文字列にする
+	if strings.Contains(fmt.Sprintf("%v", err), context.DeadlineExceeded.Error()) {
+		return ErrorCategoryTimeout
+	}
+
+	return ErrorCategoryValidation
+}
+
+// タグごとの加点
+type TagScore struct {
+	Tag        string `json:"tag"`
+	Count      int64  `json:"count"`
+	Multiplier int64  `json:"multiplier"`
+	Score      int64  `json:"score"`
+}
+
+// 実行時間
+type Timing struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// 負荷走行にかかった時間
+	Load time.Duration
+}
+
+// ベンチマーク結果をファイルに保存、比較するための構造体
+// ポータルなどから読み込まれるので、フィールドは追加だけにして変更しない
+type Result struct {
+	Score      int64            `json:"score"`
+	Passed     bool             `json:"passed"`
+	Requests   int64            `json:"requests"`
+	RPS        float64          `json:"rps"`
+	Errors     int              `json:"errors"`
+	ErrorCodes map[string]int   `json:"error_codes"`
+	Breakdown  map[string]int64 `json:"breakdown"`
+	// シナリオごとのレスポンスヘッダを受け取るまでのレイテンシのヒストグラム
+	LatencyHistograms map[string][]LatencyBucket `json:"latency_histograms"`
+	// -score-only で内容の検証を省略したか
+	ScoreOnly bool `json:"score_only"`
+	// 倍率を掛けた加点の合計とエラーによる減点
+	Addition  int64 `json:"addition"`
+	Deduction int64 `json:"deduction"`
+	// タグ名の順に並べたタグごとの加点
+	Tags []TagScore `json:"tags"`
+	// ErrorCategory ごとのエラー数
+	ErrorCategories map[string]int `json:"error_categories"`
+	StartedAt       time.Time      `json:"started_at"`
+	FinishedAt      time.Time      `json:"finished_at"`
+	ElapsedSeconds  float64        `json:"elapsed_seconds"`
+	LoadSeconds     float64        `json:"load_seconds"`
+}
+
+// isucandar.BenchmarkResult から保存用の Result を生成
+func NewResult(result *isucandar.BenchmarkResult, timing Timing) *Result {
+	addition, deduction := scoreParts(result)
+	score := SumScore(result)
+
+	r := &Result{
+		Score:             score,
+		Passed:            score > 0,
+		Requests:          telemetry.Requests(),
+		Errors:            len(result.Errors.All()),
+		ErrorCodes:        map[string]int{},
+		Breakdown:         map[string]int64{},
+		LatencyHistograms: map[string][]LatencyBucket{},
+		ScoreOnly:         scoreOnly,
+		Addition:          addition,
+		Deduction:         deduction,
+		Tags:              []TagScore{},
+		ErrorCategories: map[string]int{
+			ErrorCategoryTimeout:    0,
+			ErrorCategoryValidation: 0,
+			ErrorCategoryCritical:   0,
+		},
+		StartedAt:      timing.StartedAt,
+		FinishedAt:     timing.FinishedAt,
+		ElapsedSeconds: timing.FinishedAt.Sub(timing.StartedAt).Seconds(),
+		LoadSeconds:    timing.Load.Seconds(),
+	}
+
+	if r.ElapsedSeconds > 0 {
+		r.RPS = float64(r.Requests) / r.ElapsedSeconds
+	}
+
+	// エラーはエラーコードの組み合わせごとと分類ごとに数える
+	for _, err := range result.Errors.All() {
+		r.ErrorCodes[strings.Join(failure.GetErrorCodes(err), ":")]++
+		r.ErrorCategories[ClassifyError(err)]++
+	}
+
+	for tag, count := range result.Score.Breakdown() {
+		r.Breakdown[string(tag)] = count
+		r.Tags = append(r.Tags, TagScore{
+			Tag:        string(tag),
+			Count:      count,
+			Multiplier: ScoreMultipliers[tag],
+			Score:      count * ScoreMultipliers[tag],
+		})
+	}
+	sort.Slice(r.Tags, func(i, j int) bool {
+		return r.Tags[i].Tag < r.Tags[j].Tag
+	})
+
+	for name, histogram := range telemetry.ScenarioLatencies() {
+		r.LatencyHistograms[name] = histogram.Buckets()
+	}
+
+	return r
+}
+
+// 結果に重大なエラーが含まれるかを判定
+func (r *Result) Critical() bool {
+	return r.ErrorCategories[ErrorCategoryCritical] > 0
+}
+
+// JSON 形式でファイルに保存
+func (r *Result) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return r.Write(file)
+}
+
+// JSON 形式で書き出す
+func (r *Result) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Result.Save で保存したファイルからロード
+func LoadResult(path string) (*Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := &Result{}
+	if err := json.NewDecoder(file).Decode(r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+// 以前の結果と比べてスコアが下がったかを判定
+func (r *Result) Regressed(baseline *Result) bool {
+	return r.Score < baseline.Score
+}
+
+// 以前の結果との差分を表示用の行にして返す
+func (r *Result) Compare(baseline *Result) []string {
+	lines := []string{
+		fmt.Sprintf("score: %d -> %d (%+d)", baseline.Score, r.Score, r.Score-baseline.Score),
+		fmt.Sprintf("rps: %.1f -> %.1f (%+.1f)", baseline.RPS, r.RPS, r.RPS-baseline.RPS),
+		fmt.Sprintf("error: %d -> %d (%+d)", baseline.Errors, r.Errors, r.Errors-baseline.Errors),
+	}
+
+	// 片方だけ内容の検証を省略していればスコアを同じ条件で比べられない
+	if r.ScoreOnly != baseline.ScoreOnly {
+		lines = append(lines, fmt.Sprintf("warning: score-only: %v -> %v, scores are not comparable", baseline.ScoreOnly, r.ScoreOnly))
+	}
+
+	// 以前の結果になかったエラーコードは新しく発生したものとして表示
+	codes := []string{}
+	for code := range r.ErrorCodes {
+		if _, ok := baseline.ErrorCodes[code]; !ok {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		lines = append(lines, fmt.Sprintf("new error: %s: %d", code, r.ErrorCodes[code]))
+	}
+
+	return lines
+}
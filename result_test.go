@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/isucon/isucandar/failure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCompare(t *testing.T) {
+	baseline := &Result{
+		Score:      100,
+		RPS:        10,
+		Errors:     1,
+		ErrorCodes: map[string]int{"load:status-code": 1},
+	}
+	current := &Result{
+		Score:      80,
+		RPS:        12.5,
+		Errors:     3,
+		ErrorCodes: map[string]int{"load:status-code": 1, "load:comment": 2},
+	}
+
+	assert.Equal(t, []string{
+		"score: 100 -> 80 (-20)",
+		"rps: 10.0 -> 12.5 (+2.5)",
+		"error: 1 -> 3 (+2)",
+		"new error: load:comment: 2",
+	}, current.Compare(baseline))
+	assert.True(t, current.Regressed(baseline))
+	assert.False(t, baseline.Regressed(current))
+}
+
+func TestResultSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	result := &Result{
+		Score:      100,
+		Passed:     true,
+		ErrorCodes: map[string]int{},
+		Breakdown:  map[string]int64{"GET /": 10},
+	}
+
+	assert.NoError(t, result.Save(path))
+
+	loaded, err := LoadResult(path)
+	assert.NoError(t, err)
+	assert.Equal(t, result, loaded)
+}
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, ErrorCategoryCritical, ClassifyError(failure.NewError(ErrInitializeTimeout, errors.New("initialize"))))
+	assert.Equal(t, ErrorCategoryTimeout, ClassifyError(failure.NewError(ErrInvalidRequest, context.DeadlineExceeded)))
+	assert.Equal(t, ErrorCategoryTimeout, ClassifyError(failure.NewError(ErrInvalidAsset, fmt.Errorf("GET /image/1.png : %v", context.DeadlineExceeded))))
+	assert.Equal(t, ErrorCategoryValidation, ClassifyError(failure.NewError(ErrInvalidStatusCode, errors.New("expected(200) != actual(500)"))))
+}
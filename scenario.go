@@ -2,10 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/isucon/isucandar"
+	"github.com/isucon/isucandar/agent"
 	"github.com/isucon/isucandar/failure"
 	"github.com/isucon/isucandar/score"
 	"github.com/isucon/isucandar/worker"
@@ -13,26 +20,63 @@ import (
 
 // シナリオレベルで発生するエラーコードの定義
 const (
-	ErrFailedLoadJSON  failure.StringCode = "load-json"
-	ErrCannotNewAgent  failure.StringCode = "agent"
-	ErrInvalidRequest  failure.StringCode = "request"
-	ErrInvalidResponse failure.StringCode = "response"
+	ErrFailedLoadJSON      failure.StringCode = "load-json"
+	ErrCannotNewAgent      failure.StringCode = "agent"
+	ErrInvalidRequest      failure.StringCode = "request"
+	ErrInvalidResponse     failure.StringCode = "response"
+	ErrApplicationNotReady failure.StringCode = "not-ready"
+	ErrInitializeTimeout   failure.StringCode = "initialize-timeout"
+	ErrStaleRead           failure.StringCode = "stale-read"
 )
 
+// トップページに表示される Post の件数
+const PostsPerPage = 20
+
+// 使用中のユーザーを引いたときに選び直す回数
+const PickUserRetry = 8
+
 // シナリオで発生するスコアのタグ
 const (
-	ScoreGETLogin  score.ScoreTag = "GET /login"
-	ScorePOSTLogin score.ScoreTag = "POST /login"
-	ScoreGETRoot   score.ScoreTag = "GET /"
-	ScorePOSTRoot  score.ScoreTag = "POST /"
+	ScoreGETLogin    score.ScoreTag = "GET /login"
+	ScorePOSTLogin   score.ScoreTag = "POST /login"
+	ScoreGETRoot     score.ScoreTag = "GET /"
+	ScorePOSTRoot    score.ScoreTag = "POST /"
+	ScorePOSTComment score.ScoreTag = "POST /comment"
+	ScoreGETUserPage score.ScoreTag = "GET /@:account_name"
 )
 
 // オプションと全データを持つシナリオ構造体
 type Scenario struct {
-	Option   Option
-	Users    UserSet
-	Posts    PostSet
-	Comments CommentSet
+	Option     Option
+	Users      UserSet
+	Posts      PostSet
+	Comments   CommentSet
+	UserPicker *UserPicker
+	Autoscaler *Autoscaler
+	// -reuse-accounts で指定されたときだけ設定する
+	Accounts *AccountStore
+
+	// Prepare の時点でトップページにあった最大の Post の ID
+	initialNewestPostID int
+	// 負荷走行中に投稿に成功した Post の数
+	createdPosts int64
+	// 負荷走行中に投稿に成功した Post のうち最大の ID
+	newestCreatedPostID int64
+	// 負荷走行にかかった時間
+	loadDuration time.Duration
+}
+
+// 負荷走行で使うユーザーを Option.UserDistribution に従って選ぶ
+// 他のワーカーが使用中のユーザーは選ばれず、使い終わったら UserPicker.Release を呼ぶ
+func (s *Scenario) PickUser() (*User, bool) {
+	for i := 0; i < PickUserRetry; i++ {
+		user := s.Users.At(s.UserPicker.Index())
+		if user != nil && s.UserPicker.Acquire(user.ID) {
+			return user, true
+		}
+	}
+
+	return nil, false
 }
 
 // isucandar.PrepeareScenario を満たすメソッド
@@ -43,6 +87,13 @@ func (s *Scenario) Prepare(ctx context.Context, step *isucandar.BenchmarkStep) e
 		return failure.NewError(ErrFailedLoadJSON, err)
 	}
 
+	// ユーザーを選ぶ分布を生成
+	picker, err := NewUserPicker(s.Option.UserDistribution, s.Option.UserZipfS, s.Users.Len(), s.Option.Seed)
+	if err != nil {
+		return err
+	}
+	s.UserPicker = picker
+
 	// Post のダンプデータをロード
 	if err := s.Posts.LoadJSON("./dump/posts.json"); err != nil {
 		return failure.NewError(ErrFailedLoadJSON, err)
@@ -59,9 +110,20 @@ func (s *Scenario) Prepare(ctx context.Context, step *isucandar.BenchmarkStep) e
 		return failure.NewError(ErrCannotNewAgent, err)
 	}
 
+	// 中断したときにコネクションの goroutine が残らないように閉じる
+	defer ag.HttpClient.CloseIdleConnections()
+
 	// GET /initialize へのリクエストを実行
 	res, err := GetInitializeAction(ctx, ag)
 	if err != nil {
+		// タイムアウトは DB の初期化が遅すぎることを示すので他のエラーと区別する
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return failure.NewError(
+				ErrInitializeTimeout,
+				fmt.Errorf("initialize timed out after %s", s.Option.InitializeRequestTimeout),
+			)
+		}
 		return failure.NewError(ErrInvalidRequest, err)
 	}
 	// レスポンスの Body は必ず Close
@@ -74,31 +136,124 @@ func (s *Scenario) Prepare(ctx context.Context, step *isucandar.BenchmarkStep) e
 		WithStatusCode(200),
 	).Add(step)
 
+	// initialize から戻った直後はまだ準備ができていないアプリケーションもあるので、
+	// トップページが 200 を返すようになるまで待つ
+	if err := s.waitForSettle(ctx); err != nil {
+		return err
+	}
+
+	// 負荷走行で増えた Post の数と比べるため、負荷走行前の最新の Post を記録
+	s.recordInitialNewestPost(ctx)
+
 	return nil
 }
 
-// isucandar.PrepeareScenario を満たすメソッド
-// isucandar.Benchmark の Load ステップで実行される
-func (s *Scenario) Load(ctx context.Context, step *isucandar.BenchmarkStep) error {
-	wg := &sync.WaitGroup{}
+// 負荷走行にかかった時間を返す
+// 負荷走行が行われなければ 0 を返す
+func (s *Scenario) LoadDuration() time.Duration {
+	return s.loadDuration
+}
 
-	// 10秒おきにベンチマーク実行中であることを大会運営向けロガーに出力
-	// wg.Add(1)
-	// go func() {
-	// 	for {
-	// 		AdminLogger.Print("ベンチマーク実行中")
+// トップページにある最大の Post の ID を記録
+// 取得できなかったときは 0 のままにして、 Validation で Post の増加数を検証しない
+func (s *Scenario) recordInitialNewestPost(ctx context.Context) {
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		AdminLogger.Printf("post count: cannot record initial newest post: %v", err)
+		return
+	}
 
-	// 		select {
-	// 		case <-ctx.Done():
-	// 			return
-	// 		case <-time.After(10 * time.Second):
-	// 		}
-	// 	}
-	// }()
+	res, err := GetRootAction(ctx, ag)
+	if err != nil {
+		AdminLogger.Printf("post count: cannot record initial newest post: %v", err)
+		return
+	}
+	defer res.Body.Close()
+
+	ids := []int{}
+	if validation := ValidateResponse(res, WithStatusCode(200), WithPostIDs(&ids)); !validation.IsEmpty() {
+		AdminLogger.Printf("post count: cannot record initial newest post: %v", validation)
+		return
+	}
+
+	s.initialNewestPostID = maxPostID(ids)
+}
+
+// 投稿に成功した Post の ID を最大のものだけ記録
+func (s *Scenario) recordCreatedPost(id int) {
+	for {
+		newest := atomic.LoadInt64(&s.newestCreatedPostID)
+		if int64(id) <= newest || atomic.CompareAndSwapInt64(&s.newestCreatedPostID, newest, int64(id)) {
+			return
+		}
+	}
+}
+
+// 存在するはずの最新の Post の ID を返す
+// Prepare の時点の最新の Post と負荷走行中に投稿した Post のうち新しい方で、分からなければ 0 を返す
+func (s *Scenario) newestKnownPostID() int {
+	newest := int(atomic.LoadInt64(&s.newestCreatedPostID))
+	if s.initialNewestPostID > newest {
+		newest = s.initialNewestPostID
+	}
+	return newest
+}
+
+// Post の ID のうち最大のものを返す
+// 初期データの投稿日時は ID の順とは限らないので、先頭の Post ではなく最大の ID を使う
+func maxPostID(ids []int) int {
+	max := 0
+	for _, id := range ids {
+		if id > max {
+			max = id
+		}
+	}
+	return max
+}
+
+// トップページの死活確認を行う間隔
+const initializeSettleInterval = 100 * time.Millisecond
+
+// トップページが 200 を返すようになるまで Option.InitializeSettleTimeout を上限に待つ
+func (s *Scenario) waitForSettle(ctx context.Context) error {
+	if s.Option.InitializeSettleTimeout <= 0 {
+		return nil
+	}
+
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		return failure.NewError(ErrCannotNewAgent, err)
+	}
+
+	settleCtx, cancel := context.WithTimeout(ctx, s.Option.InitializeSettleTimeout)
+	defer cancel()
+
+	for {
+		res, err := GetRootAction(settleCtx, ag)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == 200 {
+				return nil
+			}
+		}
+
+		select {
+		case <-settleCtx.Done():
+			return failure.NewError(
+				ErrApplicationNotReady,
+				fmt.Errorf("GET / did not return 200 within %s after initialize", s.Option.InitializeSettleTimeout),
+			)
+		case <-time.After(initializeSettleInterval):
+		}
+	}
+}
 
+// 負荷走行で実行するシナリオの登録
+func init() {
 	// 成功ケースのシナリオ
-	successCase, err := worker.NewWorker(func(ctx context.Context, _ int) {
-		if user, ok := s.Users.Get(rand.Intn(s.Users.Len())); ok {
+	RegisterScenario("post-image", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
 			// 削除済みのユーザーを引いたらもう一回
 			if user.DeleteFlag != 0 {
 				return
@@ -112,24 +267,15 @@ func (s *Scenario) Load(ctx context.Context, step *isucandar.BenchmarkStep) erro
 		}
 	},
 		// 無限回繰り返す
-		worker.WithInfinityLoop(),
+		InfinityLoop,
 		// 4並列で実行
 		worker.WithMaxParallelism(4),
 	)
-	if err != nil {
-		return err
-	}
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		successCase.Process(ctx)
-	}()
 
 	// 失敗ケースのシナリオ
-	failureCase, err := worker.NewWorker(func(ctx context.Context, _ int) {
-		if user, ok := s.Users.Get(rand.Intn(s.Users.Len())); ok {
+	RegisterScenario("login-failure", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
 			// 削除済みのユーザーを引いたらもう一回
 			if user.DeleteFlag != 0 {
 				return
@@ -140,44 +286,325 @@ func (s *Scenario) Load(ctx context.Context, step *isucandar.BenchmarkStep) erro
 		}
 	},
 		// 20回繰り返す
-		worker.WithLoopCount(20),
+		20,
 		// 2並列で実行
 		worker.WithMaxParallelism(2),
 	)
-	if err != nil {
-		return err
-	}
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		failureCase.Process(ctx)
-	}()
 
 	// トップページの並び順検証シナリオ
-	orderedCase, err := worker.NewWorker(func(ctx context.Context, _ int) {
-		if user, ok := s.Users.Get(rand.Intn(s.Users.Len())); ok {
+	RegisterReadOnlyScenario("ordered-index", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
 			// トップページの並び順を検証
 			s.OrderedIndex(ctx, step, user)
 		}
 	},
 		// 無限回繰り返す
-		worker.WithInfinityLoop(),
+		InfinityLoop,
 		// 2並列で実行
 		worker.WithMaxParallelism(2),
 	)
-	if err != nil {
-		return err
-	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	// トップページのコメント数検証シナリオ
+	RegisterScenario("comment-count", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			// ログインに成功したらコメントを投稿して検証
+			if s.LoginSuccess(ctx, step, user) {
+				s.CommentCount(ctx, step, user)
+			}
+			user.ClearAgent()
+		}
+	},
+		// 無限回繰り返す
+		InfinityLoop,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// コメントの並び順検証シナリオ
+	RegisterScenario("comment-order", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			// ログインに成功したらコメントを投稿して検証
+			if s.LoginSuccess(ctx, step, user) {
+				s.CommentOrder(ctx, step, user)
+			}
+			user.ClearAgent()
+		}
+	},
+		// 5回繰り返す
+		5,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// ログインし直しても投稿がユーザーに紐づいていることの検証シナリオ
+	RegisterScenario("relogin-journey", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		s.ReloginJourney(ctx, step, randomNewUser())
+	},
+		// 3回繰り返す
+		3,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// アカウント名かパスワードが空のユーザー登録が拒否されることの検証シナリオ
+	RegisterScenario("register-empty", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		s.RegisterEmptyCredentials(ctx, step)
+	},
+		// 1回だけ実行
+		1,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// 存在しないアカウントでのログイン検証シナリオ
+	RegisterScenario("login-nonexistent", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		s.LoginNonexistent(ctx, step)
+	},
+		// 10回繰り返す
+		10,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// マルチバイト文字のアカウントでのログイン検証シナリオ
+	RegisterScenario("multibyte-login", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		s.MultibyteLogin(ctx, step)
+	},
+		// 1回だけ実行
+		1,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// ログインせずにトップページ、個別ページ、ユーザーページを閲覧するシナリオ
+	RegisterReadOnlyScenario("browse", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			s.Browse(ctx, step, user)
+			user.ClearAgent()
+		}
+	},
+		// 無限回繰り返す
+		InfinityLoop,
+		// 2並列で実行
+		worker.WithMaxParallelism(2),
+	)
+
+	// 複数行の本文の改行が保たれることの検証シナリオ
+	RegisterScenario("multiline-post", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			// ログインに成功したら複数行の本文で投稿して検証
+			if s.LoginSuccess(ctx, step, user) {
+				s.MultilinePost(ctx, step, user)
+			}
+			user.ClearAgent()
+		}
+	},
+		// 5回繰り返す
+		5,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// コメントの多い Post の表示件数検証シナリオ
+	RegisterScenario("busy-post-comments", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			// ログインに成功したらコメントを投稿して検証
+			if s.LoginSuccess(ctx, step, user) {
+				s.BusyPostComments(ctx, step, user)
+			}
+			user.ClearAgent()
+		}
+	},
+		// 5回繰り返す
+		5,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// 既存の Post にコメントを投稿し、個別ページに表示されることの検証シナリオ
+	RegisterScenario("post-comment", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			// ログインに成功したらコメントを投稿して検証
+			if s.LoginSuccess(ctx, step, user) {
+				s.PostComment(ctx, step, user)
+			}
+			user.ClearAgent()
+		}
+	},
+		// 無限回繰り返す
+		InfinityLoop,
+		// 2並列で実行
+		worker.WithMaxParallelism(2),
+	)
+
+	// ユーザーページの閲覧シナリオ
+	RegisterReadOnlyScenario("user-page", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			s.UserPage(ctx, step, user)
+			user.ClearAgent()
+		}
+	},
+		// 無限回繰り返す
+		InfinityLoop,
+		// 2並列で実行
+		worker.WithMaxParallelism(2),
+	)
+
+	// トップページから古い Post のページを順にたどる検証シナリオ
+	RegisterReadOnlyScenario("paging", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+
+			s.Paging(ctx, step, user)
+			user.ClearAgent()
+		}
+	},
+		// 無限回繰り返す
+		InfinityLoop,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+
+	// 投稿した直後に読み出して古いデータが返らないことの検証シナリオ
+	RegisterScenario("read-after-write", func(ctx context.Context, step *isucandar.BenchmarkStep, s *Scenario) {
+		if user, ok := s.PickUser(); ok {
+			defer s.UserPicker.Release(user.ID)
+			// 削除済みのユーザーを引いたらもう一回
+			if user.DeleteFlag != 0 {
+				return
+			}
+
+			// ログインに成功したら画像を投稿してすぐに読み出す
+			if s.LoginSuccess(ctx, step, user) {
+				s.ReadAfterWrite(ctx, step, user)
+			}
+			user.ClearAgent()
+		}
+	},
+		// 無限回繰り返す
+		InfinityLoop,
+		// 1並列で実行
+		worker.WithMaxParallelism(1),
+	)
+}
+
+// isucandar.PrepeareScenario を満たすメソッド
+// isucandar.Benchmark の Load ステップで実行される
+func (s *Scenario) Load(ctx context.Context, step *isucandar.BenchmarkStep) error {
+	wg := &sync.WaitGroup{}
 
-		orderedCase.Process(ctx)
+	// 結果に含めるために負荷走行の時間を計る
+	loadStartedAt := time.Now()
+	defer func() {
+		s.loadDuration = time.Since(loadStartedAt)
 	}()
 
+	// 10秒おきにベンチマーク実行中であることを大会運営向けロガーに出力
+	// wg.Add(1)
+	// go func() {
+	// 	for {
+	// 		AdminLogger.Print("ベンチマーク実行中")
+
+	// 		select {
+	// 		case <-ctx.Done():
+	// 			return
+	// 		case <-time.After(10 * time.Second):
+	// 		}
+	// 	}
+	// }()
+
+	// 混合比率が指定されていれば、1つのワーカーが毎回重みに従ってシナリオを選択して実行
+	if len(s.Option.LoadMix) > 0 {
+		w, err := NewLoadMixWorker(s.Option.LoadMix, step, s)
+		if err != nil {
+			return err
+		}
+
+		if s.Autoscaler != nil {
+			s.Autoscaler.Add(w)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go s.Autoscaler.Run(ctx, step)
+		}
+
+		w.Process(ctx)
+
+		return nil
+	}
+
+	// 選択されたシナリオごとにワーカーを生成して並行に実行
+	workers := []*worker.Worker{}
+	for _, name := range s.Option.Scenarios {
+		w, err := NewScenarioWorker(name, step, s)
+		if err != nil {
+			return err
+		}
+		workers = append(workers, w)
+	}
+
+	// -autoscale が指定されていれば並列数を負荷に応じて調整
+	if s.Autoscaler != nil {
+		for _, w := range workers {
+			s.Autoscaler.Add(w)
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.Autoscaler.Run(ctx, step)
+	}
+
+	for _, w := range workers {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			w.Process(ctx)
+		}()
+	}
+
 	wg.Wait()
 
 	return nil
@@ -186,6 +613,49 @@ func (s *Scenario) Load(ctx context.Context, step *isucandar.BenchmarkStep) erro
 // isucandar.PrepeareScenario を満たすメソッド
 // isucandar.Benchmark の Validation ステップで実行される
 func (s *Scenario) Validation(ctx context.Context, step *isucandar.BenchmarkStep) error {
+	// 負荷走行後のトップページを検証
+	s.VerifyFinalIndex(ctx, step)
+	// 投稿に成功した Post がすべて保存されていることを検証
+	// Validation で投稿する検証より先に行う
+	s.VerifyPostCountGrowth(ctx, step)
+	// ログインしていない画像投稿が拒否されることを検証
+	// -read-only ではデータを変更しうる検証は行わない
+	if !s.Option.ReadOnly {
+		s.VerifyUnauthorizedPost(ctx, step)
+	}
+	// 想定外の HTTP メソッドでサーバーエラーにならないことを検証
+	s.VerifyMethodRestrictions(ctx, step)
+	// 正規化されていないパスでサーバーエラーにならないことを検証
+	s.VerifyPathNormalization(ctx, step)
+	// 想定外の Accept ヘッダでサーバーエラーにならないことを検証
+	s.VerifyAcceptHeaders(ctx, step)
+	// トップページの Post が個別ページでも表示できることを検証
+	s.VerifyIndexPostsReachable(ctx, step)
+	// 次のページへのリンクがあれば古い Post が並ぶことを検証
+	s.VerifyNextPageLink(ctx, step)
+	// 同じ Post の画像の URL が表示のたびに変わらないことを検証
+	s.VerifyStableImageURLs(ctx, step)
+	// フラッシュメッセージが一度だけ表示されることを検証
+	s.VerifyFlashCleared(ctx, step)
+	// 一般ユーザーが BAN 管理画面を表示できないことを検証
+	s.VerifyAdminBannedGated(ctx, step)
+	if !s.Option.ReadOnly {
+		// 拡張子と中身が一致しない画像の扱いを調査
+		s.VerifyImageContentSniffing(ctx, step)
+		// 空のコメントが受け付けられないことを検証
+		s.VerifyEmptyCommentRejected(ctx, step)
+		// 別のページを表示しても CSRF トークンが使えることを検証
+		s.VerifyCSRFTokenPersistence(ctx, step)
+		// 同じアカウント名で同時にユーザー登録しても両方は成功しないことを検証
+		s.VerifyConcurrentRegistration(ctx, step)
+		// 大きすぎる画像の投稿にサーバーエラーやハングせずに応答することを検証
+		s.VerifyOversizedUpload(ctx, step)
+		// 一覧と個別ページで画像の大きさが食い違わないことを検証
+		s.VerifyImageSizes(ctx, step)
+	}
+	// アプリケーションによって実装されていないことがある機能を調べ、あれば検証
+	s.ProbeOptionalFeatures(ctx, step)
+
 	return nil
 }
 
@@ -364,20 +834,21 @@ func (s *Scenario) LoginFailure(ctx context.Context, step *isucandar.BenchmarkSt
 	return true
 }
 
-// 画像を投稿するシナリオ
-func (s *Scenario) PostImage(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+// 画像を投稿し、作成された Post を返すシナリオ
+// 投稿に失敗したら nil を返す
+func (s *Scenario) CreatePost(ctx context.Context, step *isucandar.BenchmarkStep, user *User, body string) *Post {
 	// User に紐づくユーザーエージェントを取得
 	ag, err := user.GetAgent(s.Option)
 	if err != nil {
 		step.AddError(failure.NewError(ErrCannotNewAgent, err))
-		return false
+		return nil
 	}
 
 	// トップページへのリクエストを実行
 	getRes, err := GetRootAction(ctx, ag)
 	if err != nil {
 		step.AddError(failure.NewError(ErrInvalidRequest, err))
-		return false
+		return nil
 	}
 	defer getRes.Body.Close()
 
@@ -396,26 +867,26 @@ func (s *Scenario) PostImage(ctx context.Context, step *isucandar.BenchmarkStep,
 		step.AddScore(ScoreGETRoot)
 	} else {
 		// エラーがあればここでシナリオは停止
-		return false
+		return nil
 	}
 
 	// ここで context が終了している可能性があるのでチェックして終了していたら中断
 	select {
 	case <-ctx.Done():
-		return false
+		return nil
 	default:
 	}
 
 	// 画像を投稿
 	post := &Post{
 		Mime:   "image/png",
-		Body:   randomText(),
+		Body:   body,
 		UserID: user.ID,
 	}
 	postRes, err := PostRootAction(ctx, ag, post, user.GetCSRFToken())
 	if err != nil {
 		step.AddError(failure.NewError(ErrInvalidRequest, err))
-		return false
+		return nil
 	}
 	defer postRes.Body.Close()
 
@@ -424,20 +895,42 @@ func (s *Scenario) PostImage(ctx context.Context, step *isucandar.BenchmarkStep,
 		postRes,
 		// ステータスコードは 302
 		WithStatusCode(302),
+		// リダイレクト先から Post の ID を取得
+		WithPostLocation(post),
 	)
 	postValidation.Add(step)
 
 	if postValidation.IsEmpty() {
 		// 検証結果のエラーが空ならスコアを追加
 		step.AddScore(ScorePOSTRoot)
+		// 負荷走行後に Post が増えた数と比べるために数える
+		atomic.AddInt64(&s.createdPosts, 1)
+		s.recordCreatedPost(post.ID)
 	} else {
-		return false
+		return nil
 	}
 
-	// ここで context が終了している可能性があるのでチェックして終了していたら中断
-	select {
-	case <-ctx.Done():
-		return false
+	return post
+}
+
+// 画像を投稿するシナリオ
+func (s *Scenario) PostImage(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// 画像を投稿
+	if s.CreatePost(ctx, step, user, randomText()) == nil {
+		return false
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
 	default:
 	}
 
@@ -507,3 +1000,1326 @@ func (s *Scenario) OrderedIndex(ctx context.Context, step *isucandar.BenchmarkSt
 	// 不備がなければ true を返す
 	return true
 }
+
+// マルチバイト文字や記号を含むアカウントで登録・ログインできるかを検証するシナリオ
+func (s *Scenario) MultibyteLogin(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// シードから決まるアカウント名とパスワードを持つユーザー
+	// グローバルな乱数は負荷走行中の他のワーカーも使うので、専用の乱数から生成する
+	r := rand.New(rand.NewSource(s.Option.Seed))
+	user := &User{
+		AccountName: "isu" + randomMultibyteString(r, 4),
+		Password:    randomMultibyteString(r, 4) + randomSymbolString(r, 4),
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ユーザー登録するリクエストを実行
+	registerRes, err := PostRegisterAction(ctx, ag, user.AccountName, user.Password)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer registerRes.Body.Close()
+
+	registerValidation := ValidateResponse(
+		registerRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+	)
+	registerValidation.Add(step)
+
+	if !registerValidation.IsEmpty() {
+		return false
+	}
+
+	// 登録ページに戻されたらアプリケーションがマルチバイト文字のアカウント名に対応していないので検証しない
+	if WithLocation("/register")(registerRes) == nil {
+		AdminLogger.Printf("multibyte account name is not supported: %s", user.AccountName)
+		return false
+	}
+
+	// 登録時のセッションを捨ててログインし直す
+	ag.ClearCookie()
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// ログインするリクエストを実行
+	loginRes, err := PostLoginAction(ctx, ag, user.AccountName, user.Password)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer loginRes.Body.Close()
+
+	loginValidation := ValidateResponse(
+		loginRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+		// リダイレクト先はトップページ
+		WithLocation("/"),
+	)
+	loginValidation.Add(step)
+
+	if loginValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScorePOSTLogin)
+	} else {
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// ユーザーページへのリクエストを実行
+	userRes, err := GetUserPageAction(ctx, ag, user.AccountName)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer userRes.Body.Close()
+
+	userValidation := ValidateResponse(
+		userRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 登録したアカウント名がそのまま表示されていること
+		WithUserAccountName(user.AccountName),
+	)
+	userValidation.Add(step)
+
+	if userValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETUserPage)
+	} else {
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// 多数のコメントが付いた Post の表示件数を検証するシナリオ
+func (s *Scenario) BusyPostComments(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// 画像を投稿してコメントを付ける Post を用意
+	post := s.CreatePost(ctx, step, user, randomText())
+	if post == nil {
+		return false
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// 表示件数の上限を超えるだけコメントを投稿
+	comments := []string{}
+	for i := 0; i < s.Option.CommentLimit+2; i++ {
+		// ここで context が終了している可能性があるのでチェックして終了していたら中断
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+		// 2 件目からは前のコメントと投稿日時が同じ秒にならないように待つ
+		if i > 0 && !waitCommentInterval(ctx) {
+			return false
+		}
+
+		comment := fmt.Sprintf("%s (%d)", randomText(), i)
+		commentRes, err := PostCommentAction(ctx, ag, post.ID, comment, user.GetCSRFToken())
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			return false
+		}
+		defer commentRes.Body.Close()
+
+		commentValidation := ValidateResponse(
+			commentRes,
+			// ステータスコードは 302
+			WithStatusCode(302),
+		)
+		commentValidation.Add(step)
+
+		if commentValidation.IsEmpty() {
+			// 検証結果のエラーが空ならスコアを追加
+			step.AddScore(ScorePOSTComment)
+		} else {
+			return false
+		}
+		comments = append(comments, comment)
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// Post の個別ページへのリクエストを実行
+	postRes, err := GetPostAction(ctx, ag, post.ID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 個別ページではすべてのコメントが表示される
+		WithPostComments(post.ID, len(comments), comments, false),
+	)
+	postValidation.Add(step)
+
+	if !postValidation.IsEmpty() {
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// トップページへのリクエストを実行
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	// トップページでは新しい方から上限件数のコメントだけが表示される
+	shown := comments
+	if limit := s.Option.CommentLimit; limit <= 0 {
+		shown = []string{}
+	} else if limit < len(comments) {
+		shown = comments[len(comments)-limit:]
+	}
+
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 他の投稿に押し出されてトップページに含まれていなければ検証しない
+		WithPostComments(post.ID, len(comments), shown, true),
+	)
+	getValidation.Add(step)
+
+	if getValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETRoot)
+	} else {
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// 存在しないアカウントでのログインを実行するシナリオ
+// パスワード間違いと同じ応答であること (アカウントの存在が推測できないこと) を検証する
+func (s *Scenario) LoginNonexistent(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// 初期データに存在しないアカウント名を持つユーザー
+	user := &User{
+		AccountName: fmt.Sprintf("nonexistent%d", rand.Intn(1000000)),
+		Password:    randomSymbolString(nil, 8),
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ログインするリクエストを実行
+	postRes, err := PostLoginAction(ctx, ag, user.AccountName, user.Password)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer postRes.Body.Close()
+
+	// レスポンスを検証
+	postValidation := ValidateResponse(
+		postRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+		// パスワード間違いと同じくリダイレクト先はログインページ
+		WithLocation("/login"),
+	)
+	postValidation.Add(step)
+
+	if postValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScorePOSTLogin)
+	} else {
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// リダイレクト先となるログインページの取得
+	redirectRes, err := GetLoginAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer redirectRes.Body.Close()
+
+	// レスポンスを検証
+	redirectValidation := ValidateResponse(
+		redirectRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+	)
+	redirectValidation.Add(step)
+
+	if redirectValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETLogin)
+	} else {
+		return false
+	}
+
+	// パスワード間違いと異なるメッセージならアカウントの存在が推測できるので大会運営向けに報告
+	// アプリケーションの誤りではないのでエラーにはしない
+	if err := ValidateResponse(redirectRes, WithIncludeBody("アカウント名かパスワードが間違っています")); !err.IsEmpty() {
+		AdminLogger.Printf("possible user enumeration: login for nonexistent account %s differs from wrong password: %v", user.AccountName, err)
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// コメントの投稿でトップページのコメント数が増えることを検証するシナリオ
+func (s *Scenario) CommentCount(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// コメント数が他のワーカーに影響されないように専用の Post を用意
+	post := s.CreatePost(ctx, step, user, randomText())
+	if post == nil {
+		return false
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// コメント投稿前のトップページへのリクエストを実行
+	beforeRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer beforeRes.Body.Close()
+
+	beforeValidation := ValidateResponse(
+		beforeRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 投稿直後なのでコメントは 0 件
+		// 他の投稿に押し出されてトップページに含まれていなければ検証しない
+		WithPostComments(post.ID, 0, []string{}, true),
+	)
+	beforeValidation.Add(step)
+
+	if beforeValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETRoot)
+	} else {
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// コメントを投稿
+	comment := randomText()
+	commentRes, err := PostCommentAction(ctx, ag, post.ID, comment, user.GetCSRFToken())
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer commentRes.Body.Close()
+
+	commentValidation := ValidateResponse(
+		commentRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+	)
+	commentValidation.Add(step)
+
+	if commentValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScorePOSTComment)
+	} else {
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// コメント投稿後のトップページへのリクエストを実行
+	afterRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer afterRes.Body.Close()
+
+	afterValidation := ValidateResponse(
+		afterRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// コメント数が 1 件に増えていること
+		WithPostComments(post.ID, 1, []string{comment}, true),
+	)
+	afterValidation.Add(step)
+
+	if afterValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETRoot)
+	} else {
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// 同じ秒に投稿されたコメントの並びは決まらないので、順序を検証するコメントを投稿する間隔
+const CommentInterval = time.Second
+
+// 次のコメントの投稿日時が前のコメントと同じ秒にならないように CommentInterval だけ待つ
+// 待っている間に context が終了すれば false を返す
+func waitCommentInterval(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(CommentInterval):
+		return true
+	}
+}
+
+// 投稿したコメントが古い順に表示されることを検証するシナリオ
+func (s *Scenario) CommentOrder(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// 並び順が他のワーカーに影響されないように専用の Post を用意
+	post := s.CreatePost(ctx, step, user, randomText())
+	if post == nil {
+		return false
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// 並び順が判別できるように連番付きのコメントを順に投稿
+	comments := []string{}
+	for i := 0; i < 4; i++ {
+		// ここで context が終了している可能性があるのでチェックして終了していたら中断
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+		// 2 件目からは前のコメントと投稿日時が同じ秒にならないように待つ
+		if i > 0 && !waitCommentInterval(ctx) {
+			return false
+		}
+
+		comment := fmt.Sprintf("%s #%d", randomText(), i+1)
+		commentRes, err := PostCommentAction(ctx, ag, post.ID, comment, user.GetCSRFToken())
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			return false
+		}
+		defer commentRes.Body.Close()
+
+		commentValidation := ValidateResponse(
+			commentRes,
+			// ステータスコードは 302
+			WithStatusCode(302),
+		)
+		commentValidation.Add(step)
+
+		if commentValidation.IsEmpty() {
+			// 検証結果のエラーが空ならスコアを追加
+			step.AddScore(ScorePOSTComment)
+		} else {
+			return false
+		}
+		comments = append(comments, comment)
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// Post の個別ページへのリクエストを実行
+	postRes, err := GetPostAction(ctx, ag, post.ID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 投稿した順 (古い順) に並んでいること
+		WithPostComments(post.ID, len(comments), comments, false),
+	)
+	postValidation.Add(step)
+
+	// 不備がなければ true を返す
+	return postValidation.IsEmpty()
+}
+
+// 1 回の PostComment で投稿するコメントの数
+const PostCommentsPerRound = 2
+
+// トップページにある Post にコメントを投稿し、個別ページに投稿した順で表示されることを検証するシナリオ
+// 他のワーカーのコメントが間に入ってもよいが、投稿したコメントが欠けたり前後したりしてはいけない
+func (s *Scenario) PostComment(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// トップページへのリクエストを実行
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	ids := []int{}
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// CSRF トークンを取得
+		WithCSRFToken(user),
+		// 表示されている Post の ID を取得
+		WithPostIDs(&ids),
+	)
+	getValidation.Add(step)
+
+	if getValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETRoot)
+	} else {
+		return false
+	}
+	// トップページが空なのは負荷走行後の検証で扱う
+	if len(ids) == 0 {
+		return false
+	}
+	postID := ids[rand.Intn(len(ids))]
+
+	// 並び順が判別できるように連番付きのコメントを順に投稿
+	comments := []string{}
+	for i := 0; i < PostCommentsPerRound; i++ {
+		// ここで context が終了している可能性があるのでチェックして終了していたら中断
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+		// 2 件目からは前のコメントと投稿日時が同じ秒にならないように待つ
+		if i > 0 && !waitCommentInterval(ctx) {
+			return false
+		}
+
+		comment := fmt.Sprintf("%s #%d", randomText(), i+1)
+		commentRes, err := PostCommentAction(ctx, ag, postID, comment, user.GetCSRFToken())
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			return false
+		}
+		defer commentRes.Body.Close()
+
+		commentValidation := ValidateResponse(
+			commentRes,
+			// ステータスコードは 302
+			WithStatusCode(302),
+		)
+		commentValidation.Add(step)
+
+		if commentValidation.IsEmpty() {
+			// 検証結果のエラーが空ならスコアを追加
+			step.AddScore(ScorePOSTComment)
+		} else {
+			return false
+		}
+		comments = append(comments, comment)
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// Post の個別ページへのリクエストを実行
+	postRes, err := GetPostAction(ctx, ag, postID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 投稿したコメントがすべて投稿した順に表示されていること
+		WithCommentsInOrder(postID, comments),
+	)
+	postValidation.Add(step)
+
+	// 不備がなければ true を返す
+	return postValidation.IsEmpty()
+}
+
+// ログインせずにユーザーページを閲覧するシナリオ
+func (s *Scenario) UserPage(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ユーザーページへのリクエストを実行
+	userRes, err := GetUserPageAction(ctx, ag, user.AccountName)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer userRes.Body.Close()
+
+	userValidation := ValidateResponse(
+		userRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 表示されているユーザーを検証
+		WithUserAccountName(user.AccountName),
+		// Post の並び順を検証
+		WithOrderedPosts(),
+	)
+	userValidation.Add(step)
+
+	if userValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETUserPage)
+	} else {
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// Paging でトップページからたどるページ数
+const PagingDepth = 3
+
+// トップページの末尾の Post の投稿日時を起点に GET /posts?max_created_at= で古い Post のページを順にたどるシナリオ
+// どのページも新しい順に並び、前のページの末尾より新しい Post を含まないことを検証する
+func (s *Scenario) Paging(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// トップページへのリクエストを実行
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	href := ""
+	cursor := time.Time{}
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// Post の並び順を検証
+		WithOrderedPosts(),
+		// ページの末尾にある Post の投稿日時を取得
+		WithNextPageLink(&href, &cursor),
+	)
+	getValidation.Add(step)
+
+	if getValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETRoot)
+	} else {
+		return false
+	}
+
+	for i := 0; i < PagingDepth; i++ {
+		// 投稿日時が取れなければ、それより古い Post はない
+		if cursor.IsZero() {
+			return true
+		}
+
+		// ここで context が終了している可能性があるのでチェックして終了していたら中断
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+
+		// 次のページへのリクエストを実行
+		pageRes, err := GetPostsAction(ctx, ag, cursor)
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			return false
+		}
+		defer pageRes.Body.Close()
+
+		next := time.Time{}
+		pageValidation := ValidateResponse(
+			pageRes,
+			// ステータスコードは 200
+			WithStatusCode(200),
+			// Post の並び順を検証
+			WithOrderedPosts(),
+			// 前のページの末尾より新しい Post が含まれていないこと
+			WithPostsBefore(cursor),
+			// ページの末尾にある Post の投稿日時を取得
+			WithNextPageLink(&href, &next),
+		)
+		pageValidation.Add(step)
+
+		if !pageValidation.IsEmpty() {
+			return false
+		}
+
+		// 同じ投稿日時の Post でページが埋まっていると先に進めない
+		if !next.Before(cursor) {
+			return true
+		}
+		cursor = next
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// ユーザー登録を実行するシナリオ
+// 登録に成功するとそのままログインした状態になる
+func (s *Scenario) RegisterUser(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ユーザー登録するリクエストを実行
+	registerRes, err := PostRegisterAction(ctx, ag, user.AccountName, user.Password)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer registerRes.Body.Close()
+
+	registerValidation := ValidateResponse(
+		registerRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+		// リダイレクト先はトップページ
+		WithLocation("/"),
+	)
+	registerValidation.Add(step)
+
+	// 登録に成功したときだけ true を返す
+	return registerValidation.IsEmpty()
+}
+
+// 保存済みのアカウントでログインできればそのアカウントを使い、できなければユーザー登録するシナリオ
+// どちらの場合もログインした状態になり、使ったアカウントは次の実行のために記録する
+func (s *Scenario) RegisterOrReuseUser(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	if s.Accounts == nil {
+		return s.RegisterUser(ctx, step, user)
+	}
+
+	if stored, ok := s.Accounts.Take(); ok {
+		if s.loginStoredAccount(ctx, user, stored) {
+			s.Accounts.Add(user)
+			return true
+		}
+		// initialize でユーザーが消えていればログインできないので登録し直す
+		AdminLogger.Printf("reuse accounts: cannot log in as %s, registering a new account", stored.AccountName)
+	}
+
+	if !s.RegisterUser(ctx, step, user) {
+		return false
+	}
+	s.Accounts.Add(user)
+
+	return true
+}
+
+// 保存済みのアカウントでログインし、成功したら user をそのアカウントにする
+// 消えている可能性のあるアカウントなので、失敗してもエラーにはしない
+func (s *Scenario) loginStoredAccount(ctx context.Context, user *User, stored *User) bool {
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		return false
+	}
+
+	res, err := PostLoginAction(ctx, ag, stored.AccountName, stored.Password)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+
+	// ログインに成功すればトップページにリダイレクトされる
+	if !ValidateResponse(res, WithStatusCode(302), WithLocation("/")).IsEmpty() {
+		// 失敗したときのセッションを捨ててからユーザー登録する
+		ag.ClearCookie()
+		return false
+	}
+
+	user.AccountName = stored.AccountName
+	user.Password = stored.Password
+
+	return true
+}
+
+// 画像を投稿してログアウト・再ログインした後も、投稿がユーザーに紐づいていることを検証するシナリオ
+func (s *Scenario) ReloginJourney(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	defer user.ClearAgent()
+
+	// シードから決まるアカウント名でユーザー登録
+	// -reuse-accounts で保存済みのアカウントがあればログインして使う
+	if !s.RegisterOrReuseUser(ctx, step, user) {
+		return false
+	}
+
+	// 画像を投稿
+	post := s.CreatePost(ctx, step, user, randomText())
+	if post == nil {
+		return false
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// ログアウトするリクエストを実行
+	logoutRes, err := GetLogoutAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer logoutRes.Body.Close()
+
+	logoutValidation := ValidateResponse(
+		logoutRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+	)
+	logoutValidation.Add(step)
+
+	if !logoutValidation.IsEmpty() {
+		return false
+	}
+
+	// 同じユーザーでログインし直す
+	if !s.LoginSuccess(ctx, step, user) {
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// ユーザーページへのリクエストを実行
+	userRes, err := GetUserPageAction(ctx, ag, user.AccountName)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer userRes.Body.Close()
+
+	userValidation := ValidateResponse(
+		userRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// ログアウト前に投稿した画像がこのユーザーの投稿として表示されていること
+		WithPostOwner(post.ID, user.AccountName),
+	)
+	userValidation.Add(step)
+
+	if userValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETUserPage)
+	} else {
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+const (
+	// 投稿した Post が見えるまで読み出し直す回数
+	StaleReadRetries = 3
+	// 読み出し直すまでの間隔
+	StaleReadRetryInterval = 100 * time.Millisecond
+)
+
+// 画像を投稿した直後にトップページと個別ページで見えることを検証するシナリオ
+// キャッシュやレプリカで一時的に見えないだけなら大会運営向けに報告し、
+// 読み出し直しても見えなければエラーにする
+// 検証のための読み出しなのでスコアは加算しない
+func (s *Scenario) ReadAfterWrite(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	post := s.CreatePost(ctx, step, user, randomText())
+	if post == nil {
+		return false
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	pages := []struct {
+		path    string
+		visible func() (bool, bool)
+	}{
+		{"/", func() (bool, bool) { return s.indexShowsPost(ctx, step, ag, post.ID) }},
+		{fmt.Sprintf("/posts/%d", post.ID), func() (bool, bool) { return s.detailShowsPost(ctx, step, ag, post.ID) }},
+	}
+
+	for _, page := range pages {
+		for retry := 0; ; retry++ {
+			// ここで context が終了している可能性があるのでチェックして終了していたら中断
+			select {
+			case <-ctx.Done():
+				return false
+			default:
+			}
+
+			visible, ok := page.visible()
+			if !ok {
+				return false
+			}
+
+			if visible {
+				if retry > 0 {
+					AdminLogger.Printf("stale read: post %d is visible on GET %s after %d retries", post.ID, page.path, retry)
+				}
+				break
+			}
+
+			if retry == StaleReadRetries {
+				step.AddError(failure.NewError(
+					ErrStaleRead,
+					fmt.Errorf("GET %s : post %d is not visible after posting (%d retries)", page.path, post.ID, StaleReadRetries),
+				))
+				return false
+			}
+
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(StaleReadRetryInterval):
+			}
+		}
+	}
+
+	return true
+}
+
+// トップページに Post が表示されているかを返す
+// 他の投稿に押し出されて表示されていないときは判断できないので表示されているとみなす
+func (s *Scenario) indexShowsPost(ctx context.Context, step *isucandar.BenchmarkStep, ag *agent.Agent, postID int) (bool, bool) {
+	res, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false, false
+	}
+	defer res.Body.Close()
+
+	ids := []int{}
+	validation := ValidateResponse(
+		res,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 表示された Post の ID を取得
+		WithPostIDs(&ids),
+	)
+	validation.Add(step)
+
+	if !validation.IsEmpty() {
+		return false, false
+	}
+
+	oldest := 0
+	for _, id := range ids {
+		if id == postID {
+			return true, true
+		}
+		if oldest == 0 || id < oldest {
+			oldest = id
+		}
+	}
+
+	return len(ids) > 0 && oldest > postID, true
+}
+
+// 個別ページに Post が表示されているかを返す
+func (s *Scenario) detailShowsPost(ctx context.Context, step *isucandar.BenchmarkStep, ag *agent.Agent, postID int) (bool, bool) {
+	res, err := GetPostAction(ctx, ag, postID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false, false
+	}
+	defer res.Body.Close()
+
+	// まだ見えていなければ 404 になる
+	if res.StatusCode == 404 {
+		return false, true
+	}
+
+	validation := ValidateResponse(
+		res,
+		// ステータスコードは 200
+		WithStatusCode(200),
+	)
+	validation.Add(step)
+
+	return validation.IsEmpty(), validation.IsEmpty()
+}
+
+// 複数行の本文で投稿した Post の個別ページで改行と行頭の空白が保たれていることを検証するシナリオ
+func (s *Scenario) MultilinePost(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// HTML のエスケープに影響されないように英数字と空白だけの決まった本文を使う
+	lines := []string{
+		"isu multiline first",
+		"    isu multiline indented",
+		"isu multiline last",
+	}
+
+	post := s.CreatePost(ctx, step, user, strings.Join(lines, "\n"))
+	if post == nil {
+		return false
+	}
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// 個別ページへのリクエストを実行
+	getRes, err := GetPostAction(ctx, ag, post.ID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 本文の改行が保たれていること
+		WithPostBodyLines(post.ID, lines),
+	)
+	getValidation.Add(step)
+
+	// 不備がなければ true を返す
+	return getValidation.IsEmpty()
+}
+
+// ログインせずにトップページ、個別ページ、ユーザーページを順に閲覧するシナリオ
+// 画像や静的ファイルも取得するが、データは一切変更しない
+func (s *Scenario) Browse(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// トップページへのリクエストを実行
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	ids := []int{}
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 画像と静的ファイルを検証
+		WithAssets(ctx, ag),
+		// 表示されている Post の ID を取得
+		WithPostIDs(&ids),
+	)
+	getValidation.Add(step)
+
+	if getValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETRoot)
+	} else {
+		// エラーがあればここでシナリオは停止
+		return false
+	}
+
+	// ここで context が終了している可能性があるのでチェックして終了していたら中断
+	select {
+	case <-ctx.Done():
+		return false
+	default:
+	}
+
+	// トップページにある Post の個別ページへのリクエストを実行
+	if len(ids) > 0 {
+		postRes, err := GetPostAction(ctx, ag, ids[rand.Intn(len(ids))])
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			return false
+		}
+		defer postRes.Body.Close()
+
+		postValidation := ValidateResponse(
+			postRes,
+			// ステータスコードは 200
+			WithStatusCode(200),
+		)
+		postValidation.Add(step)
+
+		if !postValidation.IsEmpty() {
+			return false
+		}
+	}
+
+	// ユーザーページへのリクエストを実行
+	userRes, err := GetUserPageAction(ctx, ag, user.AccountName)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer userRes.Body.Close()
+
+	userValidation := ValidateResponse(
+		userRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 表示されているユーザーを検証
+		WithUserAccountName(user.AccountName),
+	)
+	userValidation.Add(step)
+
+	if userValidation.IsEmpty() {
+		// 検証結果のエラーが空ならスコアを追加
+		step.AddScore(ScoreGETUserPage)
+	} else {
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// ユーザー登録を拒否したときのフラッシュメッセージ
+const registerRejectedMessage = "アカウント名は3文字以上、パスワードは6文字以上である必要があります"
+
+// アカウント名かパスワードが空のユーザー登録が拒否され、そのアカウントでログインできないことを検証するシナリオ
+// 拒否されるのが正しい挙動なので、拒否されてもエラーにはしない
+func (s *Scenario) RegisterEmptyCredentials(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	valid := randomNewUser()
+	users := []*User{
+		// アカウント名が空
+		{AccountName: "", Password: valid.Password},
+		// パスワードが空
+		{AccountName: valid.AccountName, Password: ""},
+	}
+
+	ok := true
+	for _, user := range users {
+		// ここで context が終了している可能性があるのでチェックして終了していたら中断
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+
+		ok = s.registerEmptyCredential(ctx, step, user) && ok
+	}
+
+	return ok
+}
+
+// 空の項目を含むユーザー登録を1件試す
+func (s *Scenario) registerEmptyCredential(ctx context.Context, step *isucandar.BenchmarkStep, user *User) bool {
+	defer user.ClearAgent()
+
+	// User に紐づくユーザーエージェントを取得
+	ag, err := user.GetAgent(s.Option)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// ユーザー登録するリクエストを実行
+	registerRes, err := PostRegisterAction(ctx, ag, user.AccountName, user.Password)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer registerRes.Body.Close()
+
+	registerValidation := ValidateResponse(
+		registerRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+		// リダイレクト先はユーザー登録ページ
+		WithLocation("/register"),
+	)
+	if !registerValidation.IsEmpty() {
+		step.AddError(failure.NewError(
+			ErrInvalidUser,
+			fmt.Errorf("POST /register : registration with empty account name or password is not rejected: account_name(%q)", user.AccountName),
+		))
+		return false
+	}
+
+	// 拒否された理由がフラッシュメッセージで表示される
+	getRes, err := GetRegisterAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 適切なエラーメッセージが含まれていること
+		WithIncludeBody(registerRejectedMessage),
+	)
+	getValidation.Add(step)
+
+	if !getValidation.IsEmpty() {
+		return false
+	}
+
+	// アカウントが作られていなければログインにも失敗する
+	loginRes, err := PostLoginAction(ctx, ag, user.AccountName, user.Password)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer loginRes.Body.Close()
+
+	loginValidation := ValidateResponse(
+		loginRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+		// リダイレクト先はログインページ
+		WithLocation("/login"),
+	)
+	if !loginValidation.IsEmpty() {
+		step.AddError(failure.NewError(
+			ErrInvalidUser,
+			fmt.Errorf("POST /login : account with empty account name or password is created: account_name(%q)", user.AccountName),
+		))
+		return false
+	}
+
+	return true
+}
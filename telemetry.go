@@ -0,0 +1,547 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// レイテンシのヒストグラムのバケットの上限
+// 結果ファイルの形式が変わらないように固定する
+var LatencyBucketBounds = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// レイテンシのヒストグラム
+// Counts[i] は LatencyBucketBounds[i] 以下で、それより前のバケットに入らなかったリクエスト数
+// 最後の要素は LatencyBucketBounds の最大値を超えたリクエスト数
+type LatencyHistogram struct {
+	Counts []int64
+}
+
+// レイテンシを記録
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(LatencyBucketBounds)+1)
+	}
+
+	i := sort.Search(len(LatencyBucketBounds), func(i int) bool {
+		return d <= LatencyBucketBounds[i]
+	})
+	h.Counts[i]++
+}
+
+// 結果ファイルに出力するヒストグラムのバケット
+type LatencyBucket struct {
+	// バケットの上限。最後のバケットは "+Inf"
+	LE    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+// 結果ファイルに出力する形式に変換
+func (h *LatencyHistogram) Buckets() []LatencyBucket {
+	buckets := make([]LatencyBucket, 0, len(LatencyBucketBounds)+1)
+	for i := 0; i <= len(LatencyBucketBounds); i++ {
+		bucket := LatencyBucket{LE: "+Inf"}
+		if i < len(LatencyBucketBounds) {
+			bucket.LE = LatencyBucketBounds[i].String()
+		}
+		if i < len(h.Counts) {
+			bucket.Count = h.Counts[i]
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets
+}
+
+// 遅かったリクエスト
+type SlowRequest struct {
+	Method   string
+	Path     string
+	Scenario string
+	Duration time.Duration
+}
+
+// 遅かった上位 N 件のリクエストを保持する
+// 最も速いものを先頭に持つヒープで、N 件を超えたら先頭を捨てる
+type SlowRequests struct {
+	limit    int
+	requests slowRequestHeap
+}
+
+// 遅かった上位 limit 件のリクエストを保持する SlowRequests を生成
+func NewSlowRequests(limit int) *SlowRequests {
+	return &SlowRequests{limit: limit}
+}
+
+// リクエストを記録
+func (s *SlowRequests) Observe(r SlowRequest) {
+	if s.limit <= 0 {
+		return
+	}
+
+	if len(s.requests) < s.limit {
+		heap.Push(&s.requests, r)
+		return
+	}
+
+	// 保持している中で最も速いものより遅ければ入れ替える
+	if r.Duration > s.requests[0].Duration {
+		s.requests[0] = r
+		heap.Fix(&s.requests, 0)
+	}
+}
+
+// 遅い順に並べたリクエストを返す
+func (s *SlowRequests) List() []SlowRequest {
+	list := append([]SlowRequest{}, s.requests...)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Duration > list[j].Duration
+	})
+
+	return list
+}
+
+// heap.Interface を実装する SlowRequest のスライス
+type slowRequestHeap []SlowRequest
+
+func (h slowRequestHeap) Len() int           { return len(h) }
+func (h slowRequestHeap) Less(i, j int) bool { return h[i].Duration < h[j].Duration }
+func (h slowRequestHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *slowRequestHeap) Push(x interface{}) {
+	*h = append(*h, x.(SlowRequest))
+}
+
+func (h *slowRequestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+const (
+	// -live-stats で RPS を表示する間隔
+	LiveStatsInterval = 5 * time.Second
+	// -live-stats で RPS を計算する直近の時間幅
+	LiveStatsWindow = 5 * time.Second
+)
+
+// 直近の一定時間に発生した回数を 1 秒ごとに数える構造体
+// 秒数分のリングバッファだけを使うので、リクエスト数によらずメモリ使用量は一定
+type RollingCounter struct {
+	// 数える秒数
+	window int64
+
+	mu sync.Mutex
+	// 1 秒ごとの回数
+	counts []int64
+	// counts の各要素が数えている Unix 時刻(秒)
+	seconds []int64
+}
+
+// window の秒数分を数える RollingCounter を生成
+func NewRollingCounter(window time.Duration) *RollingCounter {
+	n := int(window / time.Second)
+	if n < 1 {
+		n = 1
+	}
+
+	// 数えている途中の現在の秒の分だけ多く持つ
+	return &RollingCounter{
+		window:  int64(n),
+		counts:  make([]int64, n+1),
+		seconds: make([]int64, n+1),
+	}
+}
+
+// now に 1 回発生したことを記録
+func (c *RollingCounter) Observe(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sec := now.Unix()
+	i := int(sec % int64(len(c.counts)))
+	// 古い秒の値が残っていれば捨てる
+	if c.seconds[i] != sec {
+		c.seconds[i] = sec
+		c.counts[i] = 0
+	}
+	c.counts[i]++
+}
+
+// now の直前までの window 秒間の 1 秒あたりの回数を返す
+// 数えている途中の現在の秒は含めない
+func (c *RollingCounter) Rate(now time.Time) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sec := now.Unix()
+	total := int64(0)
+	for i := range c.counts {
+		if c.seconds[i] >= sec-c.window && c.seconds[i] < sec {
+			total += c.counts[i]
+		}
+	}
+
+	return float64(total) / float64(c.window)
+}
+
+// 数えている時間幅を返す
+func (c *RollingCounter) Window() time.Duration {
+	return time.Duration(c.window) * time.Second
+}
+
+// ベンチマーカー自身が計測する値を保持する構造体
+// スコアには影響せず、大会運営向けのサマリにのみ出力する
+type Telemetry struct {
+	// 確立した TCP コネクション数
+	connections int64
+	// 送信したリクエスト数
+	requests int64
+	// 実行中のリクエスト数
+	inflight int64
+	// 同時に実行されたリクエスト数の最大値
+	peakInflight int64
+	// -max-inflight の空きを待っているリクエスト数
+	queueDepth int64
+	// 空きを待っていたリクエスト数の最大値
+	peakQueueDepth int64
+	// レイテンシの合計(ナノ秒)
+	totalLatency int64
+
+	mu sync.Mutex
+	// シナリオごとの送信したリクエスト数
+	scenarioRequests map[string]int64
+	// シナリオごとのレイテンシのヒストグラム
+	scenarioLatencies map[string]*LatencyHistogram
+	// シナリオを実行するワーカーごとの -max-inflight の空きを待った時間
+	// ユーザーエージェントは繰り返しのたびに作り直されるので、ワーカーを表すシナリオ名で集計して件数を抑える
+	scenarioWaits map[string]*WaitStats
+	// 遅かった上位 -slowest 件のリクエスト
+	slowest *SlowRequests
+	// -live-stats で表示する直近のリクエスト数
+	// リクエストを送信する前に TrackLiveStats で設定し、以降は変更しない
+	live *RollingCounter
+}
+
+// 送信前に待たされた時間の集計
+type WaitStats struct {
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// ベンチマーク全体で共有する計測値
+var telemetry = &Telemetry{}
+
+// 確立した TCP コネクション数を返す
+func (t *Telemetry) Connections() int64 {
+	return atomic.LoadInt64(&t.connections)
+}
+
+// 送信したリクエスト数を返す
+func (t *Telemetry) Requests() int64 {
+	return atomic.LoadInt64(&t.requests)
+}
+
+// 同時に実行されたリクエスト数の最大値を返す
+func (t *Telemetry) PeakInflight() int64 {
+	return atomic.LoadInt64(&t.peakInflight)
+}
+
+// リクエストの開始を記録し、同時実行数の最大値を更新
+func (t *Telemetry) beginRequest() {
+	inflight := atomic.AddInt64(&t.inflight, 1)
+	for {
+		peak := atomic.LoadInt64(&t.peakInflight)
+		if inflight <= peak || atomic.CompareAndSwapInt64(&t.peakInflight, peak, inflight) {
+			return
+		}
+	}
+}
+
+// リクエストの終了を記録
+func (t *Telemetry) endRequest() {
+	atomic.AddInt64(&t.inflight, -1)
+}
+
+// 空きを待っていたリクエスト数の最大値を返す
+func (t *Telemetry) PeakQueueDepth() int64 {
+	return atomic.LoadInt64(&t.peakQueueDepth)
+}
+
+// 空き待ちの開始を記録し、待ち行列の長さの最大値を更新
+func (t *Telemetry) beginWait() {
+	depth := atomic.AddInt64(&t.queueDepth, 1)
+	for {
+		peak := atomic.LoadInt64(&t.peakQueueDepth)
+		if depth <= peak || atomic.CompareAndSwapInt64(&t.peakQueueDepth, peak, depth) {
+			return
+		}
+	}
+}
+
+// 空き待ちの終了を記録
+func (t *Telemetry) endWait() {
+	atomic.AddInt64(&t.queueDepth, -1)
+}
+
+// シナリオごとの空きを待った時間を返す
+func (t *Telemetry) ScenarioWaits() map[string]WaitStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	waits := make(map[string]WaitStats, len(t.scenarioWaits))
+	for name, stats := range t.scenarioWaits {
+		waits[name] = *stats
+	}
+
+	return waits
+}
+
+// リクエストが空きを待った時間をシナリオごとに記録
+func (t *Telemetry) observeWait(req *http.Request, d time.Duration) {
+	name := ScenarioNameFromContext(req.Context())
+	if name == "" || inflightLimiter == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.scenarioWaits == nil {
+		t.scenarioWaits = make(map[string]*WaitStats)
+	}
+	stats, ok := t.scenarioWaits[name]
+	if !ok {
+		stats = &WaitStats{}
+		t.scenarioWaits[name] = stats
+	}
+	stats.Count++
+	stats.Total += d
+	if d > stats.Max {
+		stats.Max = d
+	}
+}
+
+// シナリオごとの送信したリクエスト数を返す
+func (t *Telemetry) ScenarioRequests() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	requests := make(map[string]int64, len(t.scenarioRequests))
+	for name, count := range t.scenarioRequests {
+		requests[name] = count
+	}
+
+	return requests
+}
+
+// リクエストを送信したことを記録
+func (t *Telemetry) addRequest(req *http.Request) {
+	atomic.AddInt64(&t.requests, 1)
+	if t.live != nil {
+		t.live.Observe(time.Now())
+	}
+
+	name := ScenarioNameFromContext(req.Context())
+	if name == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.scenarioRequests == nil {
+		t.scenarioRequests = make(map[string]int64)
+	}
+	t.scenarioRequests[name]++
+}
+
+// シナリオごとのレイテンシのヒストグラムを返す
+func (t *Telemetry) ScenarioLatencies() map[string]*LatencyHistogram {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	latencies := make(map[string]*LatencyHistogram, len(t.scenarioLatencies))
+	for name, histogram := range t.scenarioLatencies {
+		latencies[name] = &LatencyHistogram{Counts: append([]int64{}, histogram.Counts...)}
+	}
+
+	return latencies
+}
+
+// レイテンシの合計を返す
+func (t *Telemetry) TotalLatency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.totalLatency))
+}
+
+// 直近 window 秒間のリクエスト数を数えるようにする
+// リクエストを送信する前に呼ぶ
+func (t *Telemetry) TrackLiveStats(window time.Duration) {
+	t.live = NewRollingCounter(window)
+}
+
+// ctx が終了するまで interval おきに直近の RPS を大会運営向けロガーに出力
+func (t *Telemetry) RunLiveStats(ctx context.Context, interval time.Duration) {
+	if t.live == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			AdminLogger.Printf(
+				"live: rps %.1f (last %s), requests: %d, inflight: %d",
+				t.live.Rate(now),
+				t.live.Window(),
+				t.Requests(),
+				atomic.LoadInt64(&t.inflight),
+			)
+		}
+	}
+}
+
+// 遅かった上位 n 件のリクエストを記録するようにする
+func (t *Telemetry) TrackSlowest(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.slowest = NewSlowRequests(n)
+}
+
+// 遅かった順にリクエストを返す
+func (t *Telemetry) Slowest() []SlowRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.slowest == nil {
+		return []SlowRequest{}
+	}
+
+	return t.slowest.List()
+}
+
+// リクエストのレイテンシを記録
+func (t *Telemetry) observeLatency(req *http.Request, d time.Duration) {
+	atomic.AddInt64(&t.totalLatency, int64(d))
+
+	name := ScenarioNameFromContext(req.Context())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// 遅いリクエストはシナリオ外のものも含めて記録する
+	if t.slowest != nil {
+		t.slowest.Observe(SlowRequest{
+			Method:   req.Method,
+			Path:     req.URL.Path,
+			Scenario: name,
+			Duration: d,
+		})
+	}
+
+	if name == "" {
+		return
+	}
+
+	if t.scenarioLatencies == nil {
+		t.scenarioLatencies = make(map[string]*LatencyHistogram)
+	}
+	histogram, ok := t.scenarioLatencies[name]
+	if !ok {
+		histogram = &LatencyHistogram{}
+		t.scenarioLatencies[name] = histogram
+	}
+	histogram.Observe(d)
+}
+
+// 大会運営向けロガーにサマリを出力
+func (t *Telemetry) Print() {
+	// リクエスト数に比べてコネクション数が極端に多ければ keep-alive が効いていない
+	AdminLogger.Printf("telemetry: tcp connections: %d, requests: %d", t.Connections(), t.Requests())
+	// サーバーが実際に捌いた並列度の目安
+	AdminLogger.Printf("telemetry: peak concurrency: %d", t.PeakInflight())
+
+	requests := t.ScenarioRequests()
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		AdminLogger.Printf("telemetry: scenario %s: requests: %d", name, requests[name])
+	}
+
+	// 遅かったリクエストを遅い順に出力
+	for i, r := range t.Slowest() {
+		scenario := r.Scenario
+		if scenario == "" {
+			scenario = "-"
+		}
+		AdminLogger.Printf("telemetry: slowest #%d: %s %s %s (scenario %s)", i+1, r.Duration.Round(time.Microsecond), r.Method, r.Path, scenario)
+	}
+
+	// -max-inflight が指定されていれば待たされた量を出力
+	if inflightLimiter == nil {
+		return
+	}
+	AdminLogger.Printf("telemetry: peak queue depth: %d", t.PeakQueueDepth())
+
+	// 合計の待ち時間が長いシナリオから順に出力
+	waits := t.ScenarioWaits()
+	waitNames := make([]string, 0, len(waits))
+	for name := range waits {
+		waitNames = append(waitNames, name)
+	}
+	sort.Slice(waitNames, func(i, j int) bool {
+		return waits[waitNames[i]].Total > waits[waitNames[j]].Total
+	})
+	for _, name := range waitNames {
+		stats := waits[name]
+		AdminLogger.Printf(
+			"telemetry: scenario %s: queue wait: total %s, avg %s, max %s (%d requests)",
+			name,
+			stats.Total.Round(time.Microsecond),
+			(stats.Total / time.Duration(stats.Count)).Round(time.Microsecond),
+			stats.Max.Round(time.Microsecond),
+			stats.Count,
+		)
+	}
+}
+
+// DialContext をラップして確立したコネクション数を数える
+func (t *Telemetry) WrapDialContext(dial DialContextFunc) DialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err == nil {
+			atomic.AddInt64(&t.connections, 1)
+		}
+		return conn, err
+	}
+}
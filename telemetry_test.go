@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	histogram := &LatencyHistogram{}
+	histogram.Observe(500 * time.Microsecond)
+	histogram.Observe(1 * time.Millisecond)
+	histogram.Observe(3 * time.Millisecond)
+	histogram.Observe(time.Minute)
+
+	buckets := histogram.Buckets()
+	assert.Len(t, buckets, len(LatencyBucketBounds)+1)
+	assert.Equal(t, LatencyBucket{LE: "1ms", Count: 2}, buckets[0])
+	assert.Equal(t, LatencyBucket{LE: "2ms", Count: 0}, buckets[1])
+	assert.Equal(t, LatencyBucket{LE: "5ms", Count: 1}, buckets[2])
+	assert.Equal(t, LatencyBucket{LE: "+Inf", Count: 1}, buckets[len(buckets)-1])
+}
+
+func TestSlowRequests(t *testing.T) {
+	slowest := NewSlowRequests(2)
+	for _, d := range []time.Duration{3, 1, 5, 2, 4} {
+		slowest.Observe(SlowRequest{Method: "GET", Path: "/", Duration: d * time.Millisecond})
+	}
+
+	list := slowest.List()
+	assert.Len(t, list, 2)
+	assert.Equal(t, 5*time.Millisecond, list[0].Duration)
+	assert.Equal(t, 4*time.Millisecond, list[1].Duration)
+
+	empty := NewSlowRequests(0)
+	empty.Observe(SlowRequest{Duration: time.Second})
+	assert.Empty(t, empty.List())
+}
+
+func TestRollingCounter(t *testing.T) {
+	counter := NewRollingCounter(2 * time.Second)
+	base := time.Unix(1000, 0)
+
+	counter.Observe(base)
+	counter.Observe(base.Add(1 * time.Second))
+	counter.Observe(base.Add(1500 * time.Millisecond))
+	counter.Observe(base.Add(2 * time.Second))
+
+	// 現在の秒は含めず、直前の 2 秒間を数える
+	assert.Equal(t, 1.5, counter.Rate(base.Add(2*time.Second)))
+	// 古い秒は上書きされて数えられない
+	counter.Observe(base.Add(3 * time.Second))
+	assert.Equal(t, 1.0, counter.Rate(base.Add(4*time.Second)))
+	assert.Equal(t, 2*time.Second, counter.Window())
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+)
+
+// リクエスト ID を送信するヘッダ
+const RequestIDHeader = "X-Request-Id"
+
+var (
+	// リクエスト ID の接頭辞
+	// 実行ごとに異なる ID になるように起動時刻から生成
+	requestIDPrefix = fmt.Sprintf("%x", time.Now().UnixNano())
+	// リクエスト ID の連番
+	requestIDSequence int64
+)
+
+// 一意なリクエスト ID を生成
+func newRequestID() string {
+	return fmt.Sprintf("%s-%d", requestIDPrefix, atomic.AddInt64(&requestIDSequence, 1))
+}
+
+// ベンチマーカーが送信するすべてのリクエストを仲介する http.RoundTripper
+type Transport struct {
+	option    Option
+	transport *http.Transport
+	// リクエストを送信する agent.Agent の連番
+	agentID int64
+	// シナリオごとのタイムアウトを適用するか
+	scenarioTimeout bool
+}
+
+// agent.Agent の連番
+var agentSequence int64
+
+// http.RoundTripper インターフェースを実装
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// シナリオごとのタイムアウトを設定
+	// ボディを読み終えるまでがタイムアウトの対象なので、cancel はボディを閉じたときに呼ぶ
+	var cancel context.CancelFunc
+	if t.scenarioTimeout {
+		name := ScenarioNameFromContext(req.Context())
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), t.option.ScenarioTimeouts.Get(name, t.option.RequestTimeout))
+		req = req.WithContext(ctx)
+	}
+
+	// http.RoundTripper はリクエストを書き換えてはいけないので複製してからヘッダを付与
+	// 再生時など既にリクエスト ID が付与されていればそのまま使う
+	requestID := req.Header.Get(RequestIDHeader)
+	if t.option.RequestID && requestID == "" {
+		requestID = newRequestID()
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+
+	// -header で指定されたヘッダを付与
+	if len(t.option.Headers) > 0 {
+		req = req.Clone(req.Context())
+		t.option.Headers.Apply(req)
+	}
+
+	// -fetch-images=false なら画像の本文はダウンロードせずにステータスコードだけを確かめる
+	if !t.option.FetchImages && req.Method == http.MethodGet && isImageRequest(req) {
+		req = req.Clone(req.Context())
+		req.Method = http.MethodHead
+	}
+
+	// -record が指定されていればリクエストを記録
+	recorder.Record(t.agentID, req)
+
+	// 送信したリクエスト数を数える
+	telemetry.addRequest(req)
+
+	// -max-inflight が指定されていれば空きができるまで待つ
+	// 待ち時間はレイテンシに含めない
+	res, err := t.roundTrip(req)
+	if err != nil && requestID != "" {
+		AdminLogger.Printf("request-id=%s: %s %s : %v", requestID, req.Method, req.URL.Path, err)
+	}
+
+	if cancel != nil {
+		if err != nil {
+			cancel()
+		} else {
+			res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+		}
+	}
+
+	return res, err
+}
+
+// 同時実行数の上限を守ってリクエストを送信
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	waitedAt := time.Now()
+	if err := inflightLimiter.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	defer inflightLimiter.Release()
+	telemetry.observeWait(req, time.Since(waitedAt))
+
+	// 同時に実行されているリクエスト数を数える
+	telemetry.beginRequest()
+	startedAt := time.Now()
+	res, err := t.transport.RoundTrip(req)
+	telemetry.observeLatency(req, time.Since(startedAt))
+	// 304 や HEAD などボディのないレスポンスは agent.Agent が閉じずに差し替えるので、その場で数え終える
+	if err != nil || res.Body == http.NoBody {
+		telemetry.endRequest()
+		return res, err
+	}
+
+	// ボディを読み終えるまでが送信中なので、送信枠を解放する前にボディを読み切る
+	// agent.Agent はページ内のリソースをすべて取得するまでボディを閉じないので、
+	// ボディを閉じたときに解放すると送信枠が返らなくなる
+	if inflightLimiter != nil {
+		res.Body, err = readAllBody(res.Body)
+		if err != nil {
+			telemetry.endRequest()
+			return nil, err
+		}
+	}
+
+	// ボディを読み終えるか閉じるまでを同時に実行されているリクエストとして数える
+	res.Body = &endOnCloseBody{ReadCloser: res.Body, end: telemetry.endRequest}
+
+	return res, err
+}
+
+// ボディをすべて読み込んで閉じ、読み込んだ内容を返すボディに差し替える
+func readAllBody(body io.ReadCloser) (io.ReadCloser, error) {
+	defer body.Close()
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// すべての agent.Agent で共有する同時実行数の上限
+// nil なら上限なし
+var inflightLimiter *InflightLimiter
+
+// 送信中のリクエスト数に上限を設け、超えたリクエストを待たせる
+type InflightLimiter struct {
+	slots chan struct{}
+}
+
+// 同時に n 件までリクエストを送信させる InflightLimiter を生成
+// n が 0 以下なら上限なしとして nil を返す
+func NewInflightLimiter(n int) *InflightLimiter {
+	if n <= 0 {
+		return nil
+	}
+
+	return &InflightLimiter{slots: make(chan struct{}, n)}
+}
+
+// 空きができるまで待ってから送信枠を確保する
+// 待っている間に ctx が終了すればそのエラーを返す
+func (l *InflightLimiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	// 空きがなければ待ち行列に並ぶ
+	telemetry.beginWait()
+	defer telemetry.endWait()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 確保した送信枠を解放
+func (l *InflightLimiter) Release() {
+	if l == nil {
+		return
+	}
+
+	<-l.slots
+}
+
+// 閉じたときに context をキャンセルするレスポンスボディ
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// io.Closer インターフェースを実装
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// 読み終えるか閉じたときに一度だけ end を呼ぶレスポンスボディ
+type endOnCloseBody struct {
+	io.ReadCloser
+	once sync.Once
+	end  func()
+}
+
+// io.Reader インターフェースを実装
+func (b *endOnCloseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.end)
+	}
+	return n, err
+}
+
+// io.Closer インターフェースを実装
+func (b *endOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.end)
+	return err
+}
+
+// 画像へのリクエストをキャッシュしない agent.CacheStore
+// -fetch-images=false では画像の GET を Transport で HEAD に書き換えるので、そのレスポンスをキャッシュさせない
+type imageUncachedStore struct {
+	agent.CacheStore
+}
+
+// 画像へのリクエストかを判定
+func isImageRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/image/")
+}
+
+// agent.CacheStore インターフェースを実装
+func (s *imageUncachedStore) Get(req *http.Request) *agent.Cache {
+	if isImageRequest(req) {
+		return nil
+	}
+	return s.CacheStore.Get(req)
+}
+
+// agent.CacheStore インターフェースを実装
+func (s *imageUncachedStore) Put(req *http.Request, cache *agent.Cache) {
+	if isImageRequest(req) {
+		return
+	}
+	s.CacheStore.Put(req, cache)
+}
+
+// DialContext の関数型
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// "host:ip" 形式の名前解決の上書き設定をパース
+// curl の --resolve と同様に、host への接続を ip に向ける
+func ParseResolve(value string) (string, string, error) {
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return "", "", fmt.Errorf("invalid resolve: %s (expected host:ip)", value)
+	}
+
+	ip := strings.TrimSuffix(strings.TrimPrefix(kv[1], "["), "]")
+	if net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid resolve ip: %s", value)
+	}
+
+	return kv[0], ip, nil
+}
+
+// host への接続を ip に向ける DialContext を返す
+// リクエストの URL や Host ヘッダは変わらない
+func ResolveDialContext(host, ip string, dial DialContextFunc) DialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if h, port, err := net.SplitHostPort(addr); err == nil && h == host {
+			addr = net.JoinHostPort(ip, port)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// -header で指定されたすべてのリクエストに付与するヘッダ
+type Header struct {
+	Name  string
+	Value string
+}
+
+// ヘッダ名に使える文字
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// "Name: Value" 形式のヘッダをパース
+func ParseHeader(value string) (Header, error) {
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) != 2 {
+		return Header{}, fmt.Errorf("invalid header: %s (expected \"Name: Value\")", value)
+	}
+
+	name := strings.TrimSpace(kv[0])
+	if !headerNamePattern.MatchString(name) {
+		return Header{}, fmt.Errorf("invalid header name: %q", name)
+	}
+
+	val := strings.TrimSpace(kv[1])
+	if strings.ContainsAny(val, "\r\n\x00") {
+		return Header{}, fmt.Errorf("invalid header value: %s", name)
+	}
+
+	return Header{Name: http.CanonicalHeaderKey(name), Value: val}, nil
+}
+
+// 値を伏せるヘッダ名に含まれる文字列
+var secretHeaderNames = []string{"auth", "token", "secret", "key", "cookie", "password", "session"}
+
+// 秘密情報らしい値を伏せた "Name: Value" 形式の文字列を返す
+func (h Header) Redacted() string {
+	name := strings.ToLower(h.Name)
+	for _, secret := range secretHeaderNames {
+		if strings.Contains(name, secret) {
+			return h.Name + ": [REDACTED]"
+		}
+	}
+
+	// "Bearer xxx" のような認証スキーム付きの値も伏せる
+	if fields := strings.Fields(h.Value); len(fields) == 2 && (strings.EqualFold(fields[0], "bearer") || strings.EqualFold(fields[0], "basic")) {
+		return h.Name + ": [REDACTED]"
+	}
+
+	return h.Name + ": " + h.Value
+}
+
+// 繰り返し指定できる -header の値
+type Headers []Header
+
+// flag.Value インターフェースを実装
+// 設定の出力に使われるので値は伏せる
+func (h Headers) String() string {
+	values := make([]string, 0, len(h))
+	for _, header := range h {
+		values = append(values, header.Redacted())
+	}
+
+	return strings.Join(values, ",")
+}
+
+// flag.Value インターフェースを実装
+func (h *Headers) Set(value string) error {
+	header, err := ParseHeader(value)
+	if err != nil {
+		return err
+	}
+	*h = append(*h, header)
+
+	return nil
+}
+
+// リクエストにヘッダを付与
+func (h Headers) Apply(req *http.Request) {
+	for _, header := range h {
+		// Host ヘッダは http.Request.Host で送信される
+		if header.Name == "Host" {
+			req.Host = header.Value
+			continue
+		}
+		req.Header.Set(header.Name, header.Value)
+	}
+}
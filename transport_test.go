@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeader(t *testing.T) {
+	header, err := ParseHeader("x-routing-key:  blue ")
+	assert.NoError(t, err)
+	assert.Equal(t, Header{Name: "X-Routing-Key", Value: "blue"}, header)
+
+	_, err = ParseHeader("X-Routing-Key")
+	assert.Error(t, err)
+
+	_, err = ParseHeader("X Routing: blue")
+	assert.Error(t, err)
+
+	_, err = ParseHeader(": blue")
+	assert.Error(t, err)
+}
+
+func TestHeaderRedacted(t *testing.T) {
+	assert.Equal(t, "X-Routing: blue", Header{Name: "X-Routing", Value: "blue"}.Redacted())
+	assert.Equal(t, "Authorization: [REDACTED]", Header{Name: "Authorization", Value: "secret"}.Redacted())
+	assert.Equal(t, "X-Api-Key: [REDACTED]", Header{Name: "X-Api-Key", Value: "secret"}.Redacted())
+	assert.Equal(t, "X-Forwarded-Auth: [REDACTED]", Header{Name: "X-Forwarded-Auth", Value: "Bearer abc"}.Redacted())
+	assert.Equal(t, "X-Upstream: [REDACTED]", Header{Name: "X-Upstream", Value: "Bearer abc"}.Redacted())
+}
+
+func TestHeadersApply(t *testing.T) {
+	headers := Headers{}
+	assert.NoError(t, headers.Set("X-Routing: blue"))
+	assert.NoError(t, headers.Set("Host: isu.example.com"))
+	assert.Error(t, headers.Set("invalid"))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+	headers.Apply(req)
+	assert.Equal(t, "blue", req.Header.Get("X-Routing"))
+	assert.Equal(t, "isu.example.com", req.Host)
+	assert.Equal(t, "X-Routing: blue,Host: isu.example.com", headers.String())
+}
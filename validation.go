@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +29,18 @@ const (
 	ErrCSRFToken         failure.StringCode = "csrf-token"
 	ErrInvalidPostOrder  failure.StringCode = "post-order"
 	ErrInvalidAsset      failure.StringCode = "asset"
+	ErrInvalidUser       failure.StringCode = "user"
+	ErrInvalidComment    failure.StringCode = "comment"
+	ErrInvalidPostCount  failure.StringCode = "post-count"
+	ErrUnauthorized      failure.StringCode = "unauthorized"
+	ErrStickyFlash       failure.StringCode = "flash"
+	ErrAdminLeaked       failure.StringCode = "admin"
+	ErrInvalidPaging     failure.StringCode = "paging"
+	ErrInvalidPostBody   failure.StringCode = "post-body"
+	ErrUnstableImageURL  failure.StringCode = "image-url"
+	ErrInvalidTimestamp  failure.StringCode = "timestamp"
+	ErrInvalidImageSize  failure.StringCode = "image-size"
+	ErrStaleLastLogin    failure.StringCode = "last-login"
 )
 
 // 複数のエラーを持つ構造体
@@ -82,17 +95,72 @@ func (v ValidationError) Add(step *isucandar.BenchmarkStep) {
 // レスポンスを検証するバリデータ関数の型
 type ResponseValidator func(*http.Response) error
 
+// -score-only で HTML の内容の検証を省略するか
+// CSRF トークンや Post の ID など、シナリオを進めるのに必要な値の取得は省略しない
+var scoreOnly bool
+
+// 内容を検証するバリデータ関数を -score-only で省略できるようにする
+// 省略するときは nil を返し、 ValidateResponse で無視される
+func contentValidator(validator ResponseValidator) ResponseValidator {
+	if scoreOnly {
+		return nil
+	}
+	return validator
+}
+
 // レスポンスを検証する関数
 // 複数のバリデータ関数を受け取ってすべてでレスポンスを検証し、 ValidationError を返す
 func ValidateResponse(res *http.Response, validators ...ResponseValidator) ValidationError {
 	errs := []error{}
 
+	// 省略されたバリデータ関数を除く
+	enabled := make([]ResponseValidator, 0, len(validators))
+	for _, validator := range validators {
+		if validator != nil {
+			enabled = append(enabled, validator)
+		}
+	}
+	validators = enabled
+
+	// 複数のバリデータ関数がそれぞれボディを読めるように先にすべて読み込んでおく
+	var body []byte
+	if len(validators) > 1 {
+		var err error
+		body, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return ValidationError{
+				Errors: []error{
+					failure.NewError(
+						ErrInvalidResponse,
+						fmt.Errorf(
+							"%s %s : %s",
+							res.Request.Method,
+							res.Request.URL.Path,
+							err.Error(),
+						),
+					),
+				},
+			}
+		}
+	}
+
 	for _, validator := range validators {
+		if body != nil {
+			res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
 		if err := validator(res); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
+	// リクエスト ID があれば、サーバーのログと突き合わせられるように大会運営向けロガーに出力
+	if id := res.Request.Header.Get(RequestIDHeader); id != "" {
+		if v := (ValidationError{Errors: errs}); !v.IsEmpty() {
+			AdminLogger.Printf("request-id=%s: %v", id, v)
+		}
+	}
+
 	return ValidationError{
 		Errors: errs,
 	}
@@ -120,6 +188,24 @@ func WithStatusCode(statusCode int) ResponseValidator {
 	}
 }
 
+// サーバーエラー (5xx) でないことを検証するバリデータ関数を返す高階関数
+func WithoutServerError() ResponseValidator {
+	return func(r *http.Response) error {
+		if r.StatusCode >= 500 {
+			return failure.NewError(
+				ErrInvalidStatusCode,
+				fmt.Errorf(
+					"%s %s : expected(< 500) != actual(%d)",
+					r.Request.Method,
+					r.Request.URL.Path,
+					r.StatusCode,
+				),
+			)
+		}
+		return nil
+	}
+}
+
 // レスポンスヘッダを検証するバリデータ関数を返す高階関数
 func WithLocation(val string) ResponseValidator {
 	return func(r *http.Response) error {
@@ -145,7 +231,7 @@ func WithLocation(val string) ResponseValidator {
 
 // レスポンスボディに特定の文字列が含まれていることを検証するバリデータ関数を返す高階関数
 func WithIncludeBody(val string) ResponseValidator {
-	return func(r *http.Response) error {
+	return contentValidator(func(r *http.Response) error {
 		defer r.Body.Close()
 
 		body, err := ioutil.ReadAll(r.Body)
@@ -161,7 +247,7 @@ func WithIncludeBody(val string) ResponseValidator {
 			)
 		}
 
-		if bytes.IndexAny(body, val) == -1 {
+		if !bytes.Contains(body, []byte(val)) {
 			return failure.NewError(
 				ErrNotFound,
 				fmt.Errorf(
@@ -174,7 +260,41 @@ func WithIncludeBody(val string) ResponseValidator {
 		}
 
 		return nil
-	}
+	})
+}
+
+// レスポンスボディに特定の文字列が含まれていないことを検証するバリデータ関数を返す高階関数
+func WithoutIncludeBody(val string, code failure.StringCode) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		if bytes.Contains(body, []byte(val)) {
+			return failure.NewError(
+				code,
+				fmt.Errorf(
+					"%s %s : %s is found in body",
+					r.Request.Method,
+					r.Request.URL.Path,
+					val,
+				),
+			)
+		}
+
+		return nil
+	})
 }
 
 func WithCSRFToken(user *User) ResponseValidator {
@@ -196,8 +316,9 @@ func WithCSRFToken(user *User) ResponseValidator {
 			)
 		}
 
-		node := doc.Find(`input[name="csrf_token"]`).Get(0)
-		if node == nil {
+		// 見つからないときに Get(0) は panic するので件数を確かめる
+		selection := doc.Find(`input[name="csrf_token"]`)
+		if selection.Length() == 0 {
 			return failure.NewError(
 				ErrCSRFToken,
 				fmt.Errorf(
@@ -208,7 +329,7 @@ func WithCSRFToken(user *User) ResponseValidator {
 			)
 		}
 
-		for _, attr := range node.Attr {
+		for _, attr := range selection.Get(0).Attr {
 			if attr.Key == "value" {
 				user.SetCSRFToken(attr.Val)
 			}
@@ -230,7 +351,7 @@ func WithCSRFToken(user *User) ResponseValidator {
 }
 
 func WithOrderedPosts() ResponseValidator {
-	return func(r *http.Response) error {
+	return contentValidator(func(r *http.Response) error {
 		defer r.Body.Close()
 		doc, err := goquery.NewDocumentFromReader(r.Body)
 		if err != nil {
@@ -281,66 +402,959 @@ func WithOrderedPosts() ResponseValidator {
 		return ValidationError{
 			Errors: errs,
 		}
-	}
+	})
 }
 
-// アセットの MD5 ハッシュ
-var (
-	assetsMD5 = map[string]string{
-		"favicon.ico":       "ad4b0f606e0f8465bc4c4c170b37e1a3",
-		"js/timeago.min.js": "f2d4c53400d0a46de704f5a97d6d04fb",
-		"js/main.js":        "9c309fed7e360c57a705978dab2c68ad",
-		"css/style.css":     "e4c3606a18d11863189405eb5c6ca551",
+// 画像投稿後のリダイレクト先を検証し、作成された Post の ID を取得するバリデータ関数を返す高階関数
+func WithPostLocation(post *Post) ResponseValidator {
+	return func(r *http.Response) error {
+		// リダイレクト先は /posts/:id
+		location, err := r.Location()
+		if err == nil && strings.HasPrefix(location.Path, "/posts/") {
+			if id, err := strconv.Atoi(strings.TrimPrefix(location.Path, "/posts/")); err == nil && id > 0 {
+				post.ID = id
+				return nil
+			}
+		}
+
+		return failure.NewError(
+			ErrInvalidPath,
+			fmt.Errorf(
+				"%s %s : %s, expected(/posts/:id) != actual(%s)",
+				r.Request.Method,
+				r.Request.URL.Path,
+				"Location",
+				r.Header.Get("Location"),
+			),
+		)
 	}
-)
+}
 
-// 静的ファイルを検証するバリデータ関数を返す高階関数
-func WithAssets(ctx context.Context, ag *agent.Agent) ResponseValidator {
-	return func(r *http.Response) error {
-		resources, err := ag.ProcessHTML(ctx, r, r.Body)
+// Post のコメント数と表示されているコメントを検証するバリデータ関数を返す高階関数
+// 表示されているコメントは expected と同じ内容・順序であること
+// optional が true なら Post がページに含まれていなくてもエラーにしない
+func WithPostComments(postID int, count int, expected []string, optional bool) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
 		if err != nil {
 			return failure.NewError(
-				ErrInvalidAsset,
+				ErrInvalidResponse,
 				fmt.Errorf(
-					"%s %s : %v",
+					"%s %s : %s",
 					r.Request.Method,
 					r.Request.URL.Path,
-					err,
+					err.Error(),
+				),
+			)
+		}
+
+		post := doc.Find(fmt.Sprintf("#pid_%d", postID))
+		if post.Length() == 0 {
+			if optional {
+				return nil
+			}
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : post %d is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
 				),
 			)
 		}
 
 		errs := []error{}
 
-		for uri, res := range resources {
-			path := strings.TrimPrefix(uri, ag.BaseURL.String())
-			// リソースの取得時にエラー
-			if res.Error != nil {
-				errs = append(errs,
-					failure.NewError(
-						ErrInvalidAsset,
-						fmt.Errorf(
-							"%s /%s : %v",
-							"GET",
-							path,
-							res.Error,
-						),
+		// 「comments: <b>{コメント数}</b>」の形式で表示される
+		actualCount, _ := strconv.Atoi(strings.TrimSpace(post.Find(".isu-post-comment-count b").Text()))
+		if actualCount != count {
+			errs = append(errs,
+				failure.NewError(
+					ErrInvalidComment,
+					fmt.Errorf(
+						"%s %s : comment count of post %d, expected(%d) != actual(%d)",
+						r.Request.Method,
+						r.Request.URL.Path,
+						postID,
+						count,
+						actualCount,
+					),
+				),
+			)
+		}
+
+		actual := []string{}
+		post.Find(".isu-comment .isu-comment-text").Each(func(_ int, s *goquery.Selection) {
+			actual = append(actual, strings.TrimSpace(s.Text()))
+		})
+		if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+			errs = append(errs,
+				failure.NewError(
+					ErrInvalidComment,
+					fmt.Errorf(
+						"%s %s : comments of post %d, expected(%d comments) != actual(%d comments) or invalid order",
+						r.Request.Method,
+						r.Request.URL.Path,
+						postID,
+						len(expected),
+						len(actual),
+					),
+				),
+			)
+		}
+
+		return ValidationError{
+			Errors: errs,
+		}
+	})
+}
+
+// 指定したコメントが Post のコメントに順に表示されていることを検証するバリデータ関数を返す高階関数
+// 間に他のコメントが入っていてもよく、コメントの欠落と並び順の入れ替わりをエラーにする
+func WithCommentsInOrder(postID int, expected []string) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		post := doc.Find(fmt.Sprintf("#pid_%d", postID))
+		if post.Length() == 0 {
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : post %d is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+				),
+			)
+		}
+
+		actual := []string{}
+		post.Find(".isu-comment .isu-comment-text").Each(func(_ int, s *goquery.Selection) {
+			actual = append(actual, strings.TrimSpace(s.Text()))
+		})
+
+		// expected を先頭から順に actual の中で探す
+		next := 0
+		for _, comment := range actual {
+			if next < len(expected) && comment == expected[next] {
+				next++
+			}
+		}
+		if next == len(expected) {
+			return nil
+		}
+
+		// 見つからなかったコメントが前にあれば並び順の入れ替わり、なければ欠落
+		for _, comment := range actual {
+			if comment == expected[next] {
+				return failure.NewError(
+					ErrInvalidComment,
+					fmt.Errorf(
+						"%s %s : comments of post %d are not in posted order",
+						r.Request.Method,
+						r.Request.URL.Path,
+						postID,
 					),
 				)
-				continue
 			}
+		}
+		return failure.NewError(
+			ErrInvalidComment,
+			fmt.Errorf(
+				"%s %s : comment %d of %d posted to post %d is not found",
+				r.Request.Method,
+				r.Request.URL.Path,
+				next+1,
+				len(expected),
+				postID,
+			),
+		)
+	})
+}
 
-			// http.Response.Body を閉じる
-			defer res.Response.Body.Close()
+// ユーザーページに指定したアカウント名が表示されていることを検証するバリデータ関数を返す高階関数
+func WithUserAccountName(accountName string) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
 
-			// ステータスコードが 304 ならこれ以上の検証をしない
-			if res.Response.StatusCode == 304 {
-				continue
-			}
+		// 「{アカウント名}さん」の形式で表示される
+		actual := strings.TrimSuffix(strings.TrimSpace(doc.Find(".isu-user-account-name").First().Text()), "さん")
+		if actual != accountName {
+			return failure.NewError(
+				ErrInvalidUser,
+				fmt.Errorf(
+					"%s %s : expected(%s) != actual(%s)",
+					r.Request.Method,
+					r.Request.URL.Path,
+					accountName,
+					actual,
+				),
+			)
+		}
 
-			expectedMD5, ok := assetsMD5[path]
-			if !ok {
-				// 定義にないリソースなら検証しない
+		return nil
+	})
+}
+
+// Post のコメント数を取得するバリデータ関数を返す高階関数
+func WithPostCommentCount(postID int, count *int) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		post := doc.Find(fmt.Sprintf("#pid_%d", postID))
+		if post.Length() == 0 {
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : post %d is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+				),
+			)
+		}
+
+		// 「comments: <b>{コメント数}</b>」の形式で表示される
+		actual, err := strconv.Atoi(strings.TrimSpace(post.Find(".isu-post-comment-count b").Text()))
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidComment,
+				fmt.Errorf(
+					"%s %s : comment count of post %d is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+				),
+			)
+		}
+		*count = actual
+
+		return nil
+	}
+}
+
+// ログインしていないリクエストが拒否されたことを検証するバリデータ関数を返す高階関数
+// ログインページへのリダイレクトか 4xx のステータスコードであること
+func WithLoginRequired() ResponseValidator {
+	return func(r *http.Response) error {
+		if r.StatusCode >= 400 && r.StatusCode < 500 {
+			return nil
+		}
+		if r.StatusCode == 302 && WithLocation("/login")(r) == nil {
+			return nil
+		}
+
+		return failure.NewError(
+			ErrUnauthorized,
+			fmt.Errorf(
+				"%s %s : request without login is not rejected: status(%d), Location(%s)",
+				r.Request.Method,
+				r.Request.URL.Path,
+				r.StatusCode,
+				r.Header.Get("Location"),
+			),
+		)
+	}
+}
+
+// 管理者でないユーザーが管理画面を表示できないことを検証するバリデータ関数を返す高階関数
+// リダイレクトか 403/404 のステータスコードで、管理画面のフォームが含まれていないこと
+func WithoutAdminPage() ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+
+		switch r.StatusCode {
+		case 301, 302, 303, 307, 403, 404:
+		default:
+			return failure.NewError(
+				ErrAdminLeaked,
+				fmt.Errorf(
+					"%s %s : request by non-admin user is not rejected: status(%d)",
+					r.Request.Method,
+					r.Request.URL.Path,
+					r.StatusCode,
+				),
+			)
+		}
+
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		// 拒否するステータスコードでも管理画面のフォームを返していたら漏洩している
+		if doc.Find(`form[action="/admin/banned"]`).Length() > 0 {
+			return failure.NewError(
+				ErrAdminLeaked,
+				fmt.Errorf(
+					"%s %s : admin page is shown to non-admin user",
+					r.Request.Method,
+					r.Request.URL.Path,
+				),
+			)
+		}
+
+		return nil
+	})
+}
+
+// Post の画像の URL を取得するバリデータ関数を返す高階関数
+func WithPostImageURL(postID int, src *string) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		val, ok := doc.Find(fmt.Sprintf("#pid_%d .isu-post-image img", postID)).First().Attr("src")
+		if !ok || val == "" {
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : image of post %d is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+				),
+			)
+		}
+		*src = val
+
+		return nil
+	}
+}
+
+// 次のページへのリンクと、ページの末尾にある Post の投稿日時を取得するバリデータ関数を返す高階関数
+// リンクがなければ href は空のままにする
+func WithNextPageLink(href *string, oldest *time.Time) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		for _, selector := range []string{`a[rel="next"]`, "#isu-post-more a"} {
+			if val, ok := doc.Find(selector).First().Attr("href"); ok && val != "" {
+				*href = val
+				break
+			}
+		}
+
+		if val, ok := doc.Find(".isu-posts .isu-post").Last().Attr("data-created-at"); ok {
+			if createdAt, err := time.Parse(time.RFC3339, val); err == nil {
+				*oldest = createdAt
+			}
+		}
+
+		return nil
+	}
+}
+
+// ページの Post がすべて cursor 以前に投稿されたものであることを検証するバリデータ関数を返す高階関数
+func WithPostsBefore(cursor time.Time) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		errs := []error{}
+		doc.Find(".isu-posts .isu-post").Each(func(_ int, s *goquery.Selection) {
+			idAttr, _ := s.Attr("id")
+			createdAtAttr, exists := s.Attr("data-created-at")
+			if !exists {
+				return
+			}
+
+			createdAt, err := time.Parse(time.RFC3339, createdAtAttr)
+			if err == nil && !createdAt.After(cursor) {
+				return
+			}
+
+			errs = append(errs,
+				failure.NewError(
+					ErrInvalidPaging,
+					fmt.Errorf(
+						"%s %s : %s is newer than previous page: %s > %s",
+						r.Request.Method,
+						r.Request.URL.Path,
+						idAttr,
+						createdAtAttr,
+						cursor.Format(time.RFC3339),
+					),
+				),
+			)
+		})
+
+		return ValidationError{
+			Errors: errs,
+		}
+	})
+}
+
+// 改行として扱う <br> タグ
+// <br> の後ろに改行文字が続く書き方も 1 つの改行として扱う
+var brTagPattern = regexp.MustCompile(`(?i)<br\s*/?>\n?`)
+
+// Post の本文の改行と空白が保たれていることを検証するバリデータ関数を返す高階関数
+// 改行は改行文字のままでも <br> に置き換えられていてもよい
+func WithPostBodyLines(postID int, lines []string) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		text, err := doc.Find(fmt.Sprintf("#pid_%d .isu-post-text", postID)).First().Html()
+		if err != nil || text == "" {
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : body of post %d is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+				),
+			)
+		}
+
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		text = brTagPattern.ReplaceAllString(text, "\n")
+		if !strings.Contains(text, strings.Join(lines, "\n")) {
+			return failure.NewError(
+				ErrInvalidPostBody,
+				fmt.Errorf(
+					"%s %s : line breaks of post %d body are not preserved",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+				),
+			)
+		}
+
+		return nil
+	})
+}
+
+// ベンチマーカーとアプリケーションの時計のずれとして許容する時間
+const TimestampClockSkew = time.Minute
+
+// Post ごとに timeago.js が読む投稿日時があり、未来の日時でないことを検証するバリデータ関数を返す高階関数
+func WithPostTimestamps() ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		errs := []error{}
+		now := time.Now().Add(TimestampClockSkew)
+		doc.Find(".isu-posts .isu-post").Each(func(_ int, s *goquery.Selection) {
+			idAttr, _ := s.Attr("id")
+
+			datetime, exists := s.Find("time.timeago").First().Attr("datetime")
+			if !exists {
+				errs = append(errs,
+					failure.NewError(
+						ErrInvalidTimestamp,
+						fmt.Errorf(
+							"%s %s : timestamp of %s is not found",
+							r.Request.Method,
+							r.Request.URL.Path,
+							idAttr,
+						),
+					),
+				)
+				return
+			}
+
+			createdAt, err := time.Parse(time.RFC3339, datetime)
+			if err != nil {
+				errs = append(errs,
+					failure.NewError(
+						ErrInvalidTimestamp,
+						fmt.Errorf(
+							"%s %s : invalid timestamp of %s: %s",
+							r.Request.Method,
+							r.Request.URL.Path,
+							idAttr,
+							datetime,
+						),
+					),
+				)
+				return
+			}
+
+			if createdAt.After(now) {
+				errs = append(errs,
+					failure.NewError(
+						ErrInvalidTimestamp,
+						fmt.Errorf(
+							"%s %s : timestamp of %s is in the future: %s",
+							r.Request.Method,
+							r.Request.URL.Path,
+							idAttr,
+							datetime,
+						),
+					),
+				)
+			}
+		})
+
+		return ValidationError{
+			Errors: errs,
+		}
+	})
+}
+
+// ページに表示されている Post ごとの画像の URL を取得するバリデータ関数を返す高階関数
+func WithPostImageURLs(urls map[int]string) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		doc.Find(".isu-posts .isu-post").Each(func(_ int, s *goquery.Selection) {
+			idAttr, exists := s.Attr("id")
+			if !exists {
+				return
+			}
+			id, err := strconv.Atoi(strings.TrimPrefix(idAttr, "pid_"))
+			if err != nil {
+				return
+			}
+			if src, ok := s.Find(".isu-post-image img").First().Attr("src"); ok {
+				urls[id] = src
+			}
+		})
+
+		return nil
+	}
+}
+
+// 最終ログイン日時の表示を取得するバリデータ関数を返す高階関数
+// 表示がなければ found を false にしてエラーにはしない
+func WithLastLogin(lastLogin *time.Time, found *bool) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		selection := doc.Find(".isu-last-login").First()
+		if selection.Length() == 0 {
+			*found = false
+			return nil
+		}
+		*found = true
+
+		// time 要素があれば datetime 属性、なければ表示された文字列を日時とみなす
+		datetime, exists := selection.Find("time").First().Attr("datetime")
+		if !exists {
+			datetime, exists = selection.Attr("datetime")
+		}
+		if !exists {
+			datetime = strings.TrimSpace(selection.Text())
+		}
+
+		t, err := time.Parse(time.RFC3339, datetime)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidTimestamp,
+				fmt.Errorf(
+					"%s %s : invalid last login: %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					datetime,
+				),
+			)
+		}
+		*lastLogin = t
+
+		return nil
+	}
+}
+
+// ページの先頭から、先頭の Post と同じ投稿日時の Post の ID をすべて取得するバリデータ関数を返す高階関数
+// 投稿日時は秒単位なので、同じ秒に投稿された Post の並びは決まらない
+func WithLatestPostIDs(ids *[]int) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		posts := doc.Find(".isu-posts .isu-post")
+		if posts.Length() == 0 {
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : post is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+				),
+			)
+		}
+
+		latestCreatedAt, _ := posts.First().Attr("data-created-at")
+		posts.EachWithBreak(func(i int, s *goquery.Selection) bool {
+			if createdAt, _ := s.Attr("data-created-at"); i > 0 && createdAt != latestCreatedAt {
+				return false
+			}
+			idAttr, _ := s.Attr("id")
+			id, _ := strconv.Atoi(strings.TrimPrefix(idAttr, "pid_"))
+			*ids = append(*ids, id)
+			return true
+		})
+
+		return nil
+	}
+}
+
+// ページの先頭にある Post の ID を取得するバリデータ関数を返す高階関数
+func WithLatestPost(post *Post) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		idAttr, exists := doc.Find(".isu-posts .isu-post").First().Attr("id")
+		if !exists {
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : post is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+				),
+			)
+		}
+		post.ID, _ = strconv.Atoi(strings.TrimPrefix(idAttr, "pid_"))
+
+		return nil
+	}
+}
+
+// 指定した Post が指定したアカウントの投稿として表示されていることを検証するバリデータ関数を返す高階関数
+func WithPostOwner(postID int, accountName string) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		post := doc.Find(fmt.Sprintf("#pid_%d", postID))
+		if post.Length() == 0 {
+			return failure.NewError(
+				ErrNotFound,
+				fmt.Errorf(
+					"%s %s : post %d is not found",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+				),
+			)
+		}
+
+		actual := strings.TrimSpace(post.Find(".isu-post-account-name").First().Text())
+		if actual != accountName {
+			return failure.NewError(
+				ErrInvalidUser,
+				fmt.Errorf(
+					"%s %s : owner of post %d, expected(%s) != actual(%s)",
+					r.Request.Method,
+					r.Request.URL.Path,
+					postID,
+					accountName,
+					actual,
+				),
+			)
+		}
+
+		return nil
+	})
+}
+
+// ページに含まれる Post の ID をすべて取得するバリデータ関数を返す高階関数
+func WithPostIDs(ids *[]int) ResponseValidator {
+	return func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		doc.Find(".isu-posts .isu-post").Each(func(_ int, s *goquery.Selection) {
+			idAttr, exists := s.Attr("id")
+			if !exists {
+				return
+			}
+			if id, err := strconv.Atoi(strings.TrimPrefix(idAttr, "pid_")); err == nil {
+				*ids = append(*ids, id)
+			}
+		})
+
+		return nil
+	}
+}
+
+// ページに含まれる Post の件数を検証するバリデータ関数を返す高階関数
+func WithPostCount(count int) ResponseValidator {
+	return contentValidator(func(r *http.Response) error {
+		defer r.Body.Close()
+		doc, err := goquery.NewDocumentFromReader(r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidResponse,
+				fmt.Errorf(
+					"%s %s : %s",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err.Error(),
+				),
+			)
+		}
+
+		actual := doc.Find(".isu-posts .isu-post").Length()
+		if actual != count {
+			return failure.NewError(
+				ErrInvalidPostCount,
+				fmt.Errorf(
+					"%s %s : expected(%d posts) != actual(%d posts)",
+					r.Request.Method,
+					r.Request.URL.Path,
+					count,
+					actual,
+				),
+			)
+		}
+
+		return nil
+	})
+}
+
+// アセットの MD5 ハッシュ
+var (
+	assetsMD5 = map[string]string{
+		"favicon.ico":       "ad4b0f606e0f8465bc4c4c170b37e1a3",
+		"js/timeago.min.js": "f2d4c53400d0a46de704f5a97d6d04fb",
+		"js/main.js":        "9c309fed7e360c57a705978dab2c68ad",
+		"css/style.css":     "e4c3606a18d11863189405eb5c6ca551",
+	}
+)
+
+// 静的ファイルを検証するバリデータ関数を返す高階関数
+func WithAssets(ctx context.Context, ag *agent.Agent) ResponseValidator {
+	return func(r *http.Response) error {
+		resources, err := ag.ProcessHTML(ctx, r, r.Body)
+		if err != nil {
+			return failure.NewError(
+				ErrInvalidAsset,
+				fmt.Errorf(
+					"%s %s : %v",
+					r.Request.Method,
+					r.Request.URL.Path,
+					err,
+				),
+			)
+		}
+
+		errs := []error{}
+
+		for uri, res := range resources {
+			path := strings.TrimPrefix(uri, ag.BaseURL.String())
+			// リソースの取得時にエラー
+			if res.Error != nil {
+				errs = append(errs,
+					failure.NewError(
+						ErrInvalidAsset,
+						fmt.Errorf(
+							"%s /%s : %v",
+							"GET",
+							path,
+							res.Error,
+						),
+					),
+				)
+				continue
+			}
+
+			// http.Response.Body を閉じる
+			defer res.Response.Body.Close()
+
+			// ステータスコードが 304 ならこれ以上の検証をしない
+			if res.Response.StatusCode == 304 {
+				continue
+			}
+
+			expectedMD5, ok := assetsMD5[path]
+			if !ok {
+				// 定義にないリソースなら検証しない
+				continue
+			}
+
+			// -score-only では内容を比較せず、ステータスコードだけを検証する
+			if scoreOnly {
+				if res.Response.StatusCode != 200 {
+					errs = append(errs,
+						failure.NewError(
+							ErrInvalidAsset,
+							fmt.Errorf(
+								"%s /%s : expected(%d) != actual(%d)",
+								"GET",
+								path,
+								200,
+								res.Response.StatusCode,
+							),
+						),
+					)
+				}
 				continue
 			}
 
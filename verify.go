@@ -0,0 +1,1393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/isucon/isucandar"
+	"github.com/isucon/isucandar/agent"
+	"github.com/isucon/isucandar/failure"
+)
+
+// 負荷走行後のトップページを検証するシナリオ
+// 大量に投稿された後でも新しい方から PostsPerPage 件だけが順に並び、先頭が最新の Post であること
+func (s *Scenario) VerifyFinalIndex(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// トップページへのリクエストを実行
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	// レスポンスを検証
+	latestIDs := []int{}
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// Post の件数を検証
+		WithPostCount(PostsPerPage),
+		// Post の並び順を検証
+		WithOrderedPosts(),
+		// Post の投稿日時を検証
+		WithPostTimestamps(),
+		// 先頭の Post と同じ投稿日時の Post の ID を取得
+		WithLatestPostIDs(&latestIDs),
+	)
+	getValidation.Add(step)
+
+	if !getValidation.IsEmpty() {
+		return false
+	}
+
+	// 並び順が正しくても古いキャッシュを返していれば、先頭に最新の Post が含まれない
+	// 同じ秒に投稿された Post の順序は決まらないので、先頭と同じ投稿日時の Post のどれかが最新であればよい
+	newest := s.newestKnownPostID()
+	if newest > 0 && maxPostID(latestIDs) < newest {
+		step.AddError(failure.NewError(
+			ErrStaleRead,
+			fmt.Errorf("GET / : first post is %d, but post %d is the newest", latestIDs[0], newest),
+		))
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return true
+}
+
+// ログインしていないユーザーによる画像投稿が拒否されることを検証するシナリオ
+func (s *Scenario) VerifyUnauthorizedPost(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// 投稿前の最新の Post を取得
+	before := &Post{}
+	if !s.fetchLatestPost(ctx, step, ag, before) {
+		return false
+	}
+
+	// ログインせずに画像を投稿
+	post := &Post{
+		Mime: "image/png",
+		Body: randomText(),
+	}
+	postRes, err := PostRootAction(ctx, ag, post, "")
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// ログインページへのリダイレクトか 4xx で拒否されること
+		WithLoginRequired(),
+	)
+	postValidation.Add(step)
+
+	// 投稿後の最新の Post を取得
+	after := &Post{}
+	if !s.fetchLatestPost(ctx, step, ag, after) {
+		return false
+	}
+
+	// 最新の Post が変わっていれば投稿が作成されてしまっている
+	if before.ID != after.ID {
+		step.AddError(failure.NewError(
+			ErrUnauthorized,
+			fmt.Errorf("POST / : post %d is created without login", after.ID),
+		))
+		return false
+	}
+
+	// 不備がなければ true を返す
+	return postValidation.IsEmpty()
+}
+
+// トップページの先頭にある Post を取得
+func (s *Scenario) fetchLatestPost(ctx context.Context, step *isucandar.BenchmarkStep, ag *agent.Agent, post *Post) bool {
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 先頭の Post の ID を取得
+		WithLatestPost(post),
+	)
+	getValidation.Add(step)
+
+	return getValidation.IsEmpty()
+}
+
+// 負荷走行で投稿に成功した数だけ Post が増えていることを検証するシナリオ
+// Post の ID は連番で振られるので、最大の Post の ID の増加分を Post の増加数とみなす
+func (s *Scenario) VerifyPostCountGrowth(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// 負荷走行前の最新の Post が分からなければ比べられない
+	if s.initialNewestPostID == 0 {
+		AdminLogger.Printf("post count: initial newest post is unknown, skipped")
+		return true
+	}
+
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	res, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer res.Body.Close()
+
+	ids := []int{}
+	validation := ValidateResponse(
+		res,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 表示された Post の ID を取得
+		WithPostIDs(&ids),
+	)
+	validation.Add(step)
+
+	if !validation.IsEmpty() {
+		return false
+	}
+
+	newest := maxPostID(ids)
+	created := atomic.LoadInt64(&s.createdPosts)
+	grown := int64(newest - s.initialNewestPostID)
+	AdminLogger.Printf("post count: created %d posts, newest post id %d -> %d (%+d)", created, s.initialNewestPostID, newest, grown)
+
+	if grown < created {
+		step.AddError(failure.NewError(
+			ErrInvalidPostCount,
+			fmt.Errorf("GET / : %d posts are created, but newest post id grew by %d (%d missing)", created, grown, created-grown),
+		))
+		return false
+	}
+
+	return true
+}
+
+// 想定外の HTTP メソッドで送信するリクエスト
+// データを変更しないように、受け付けられないはずの組み合わせだけを並べる
+var unexpectedMethodRequests = []struct {
+	Method string
+	Path   string
+}{
+	{http.MethodGet, "/comment"},
+	{http.MethodDelete, "/"},
+	{http.MethodPut, "/"},
+	{http.MethodDelete, "/login"},
+	{http.MethodPut, "/login"},
+	{http.MethodDelete, "/register"},
+	{http.MethodPatch, "/comment"},
+	{http.MethodDelete, "/posts/1"},
+}
+
+// 想定外の HTTP メソッドに対してサーバーエラーを返さないことを検証するシナリオ
+// 検証のためのリクエストなのでスコアは加算しない
+func (s *Scenario) VerifyMethodRestrictions(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	ok := true
+	for _, r := range unexpectedMethodRequests {
+		res, err := RequestAction(ctx, ag, r.Method, r.Path)
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			ok = false
+			continue
+		}
+
+		validation := ValidateResponse(
+			res,
+			// 404 や 405 など、サーバーエラー以外であること
+			WithoutServerError(),
+		)
+		res.Body.Close()
+		validation.Add(step)
+
+		ok = ok && validation.IsEmpty()
+	}
+
+	return ok
+}
+
+// HTML のページに送る想定外の Accept ヘッダ
+var unexpectedAcceptHeaders = []string{
+	"application/json",
+	"image/png",
+	"text/html;q=0",
+	"*/*;q=invalid",
+	"application/xml, text/csv;q=0.5",
+}
+
+// 想定外の Accept ヘッダに対してサーバーエラーを返さないことを検証するシナリオ
+// HTML 以外で返すのは誤りではないので、大会運営向けに報告するだけにする
+// 検証のためのリクエストなのでスコアは加算しない
+func (s *Scenario) VerifyAcceptHeaders(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// 個別ページを表示する Post を決める
+	post := &Post{}
+	if !s.fetchLatestPost(ctx, step, ag, post) {
+		return false
+	}
+
+	paths := []string{
+		"/",
+		"/login",
+		"/register",
+		fmt.Sprintf("/posts/%d", post.ID),
+	}
+	if user := s.randomActiveUser(); user != nil {
+		paths = append(paths, "/@"+user.AccountName)
+	}
+
+	ok := true
+	for _, path := range paths {
+		for _, accept := range unexpectedAcceptHeaders {
+			res, err := GetWithAcceptAction(ctx, ag, path, accept)
+			if err != nil {
+				step.AddError(failure.NewError(ErrInvalidRequest, err))
+				ok = false
+				continue
+			}
+
+			validation := ValidateResponse(
+				res,
+				// 406 など、サーバーエラー以外であること
+				WithoutServerError(),
+			)
+			res.Body.Close()
+
+			// どの Accept ヘッダで失敗したか分かるようにする
+			if !validation.IsEmpty() {
+				step.AddError(failure.NewError(
+					ErrInvalidStatusCode,
+					fmt.Errorf("GET %s : server error with Accept(%s): status(%d)", path, accept, res.StatusCode),
+				))
+				ok = false
+				continue
+			}
+
+			if contentType := res.Header.Get("Content-Type"); res.StatusCode == 200 && !strings.HasPrefix(contentType, "text/html") {
+				AdminLogger.Printf("accept header: GET %s with Accept(%s) is served as %s", path, accept, contentType)
+			}
+		}
+	}
+
+	return ok
+}
+
+// 正規化されていないパスで送信するリクエスト
+// リダイレクトでも 404 でもよいが、サーバーエラーにはならないこと
+var denormalizedPaths = []string{
+	"//",
+	"/login/",
+	"//login",
+	"/register/",
+	"/posts/",
+	"/posts//1",
+	"/@",
+	"/./login",
+	"/image/",
+}
+
+// 正規化されていないパスに対してサーバーエラーを返さないことを検証するシナリオ
+// 検証のためのリクエストなのでスコアは加算しない
+func (s *Scenario) VerifyPathNormalization(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	ok := true
+	for _, path := range denormalizedPaths {
+		res, err := RequestRawPathAction(ctx, ag, http.MethodGet, path)
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			ok = false
+			continue
+		}
+
+		validation := ValidateResponse(
+			res,
+			// リダイレクトや 404 など、サーバーエラー以外であること
+			WithoutServerError(),
+		)
+		res.Body.Close()
+		validation.Add(step)
+
+		ok = ok && validation.IsEmpty()
+	}
+
+	return ok
+}
+
+// トップページに表示されている Post がすべて個別ページでも表示できることを検証するシナリオ
+// トップページにあるのに個別ページが 404 になるのは不整合
+func (s *Scenario) VerifyIndexPostsReachable(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// トップページへのリクエストを実行
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	ids := []int{}
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 表示されている Post の ID を取得
+		WithPostIDs(&ids),
+	)
+	getValidation.Add(step)
+
+	if !getValidation.IsEmpty() {
+		return false
+	}
+
+	ok := true
+	for _, id := range ids {
+		// 個別ページへのリクエストを実行
+		postRes, err := GetPostAction(ctx, ag, id)
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			ok = false
+			continue
+		}
+
+		postValidation := ValidateResponse(
+			postRes,
+			// ステータスコードは 200
+			WithStatusCode(200),
+		)
+		postRes.Body.Close()
+		postValidation.Add(step)
+
+		ok = ok && postValidation.IsEmpty()
+	}
+
+	return ok
+}
+
+// トップページに次のページへのリンクがあれば、たどった先に古い Post が並ぶことを検証するシナリオ
+// 元の実装は JavaScript で /posts を読み込むのでリンクがなければ何もしない
+func (s *Scenario) VerifyNextPageLink(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// トップページへのリクエストを実行
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	href := ""
+	oldest := time.Time{}
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 次のページへのリンクを取得
+		WithNextPageLink(&href, &oldest),
+	)
+	getValidation.Add(step)
+
+	if !getValidation.IsEmpty() {
+		return false
+	}
+	if href == "" || oldest.IsZero() {
+		AdminLogger.Printf("next page link is not found in top page, skipped")
+		return true
+	}
+
+	// 次のページへのリクエストを実行
+	nextRes, err := RequestAction(ctx, ag, http.MethodGet, href)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer nextRes.Body.Close()
+
+	nextValidation := ValidateResponse(
+		nextRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// Post の並び順を検証
+		WithOrderedPosts(),
+		// トップページの末尾より新しい Post が含まれていないこと
+		WithPostsBefore(oldest),
+	)
+	nextValidation.Add(step)
+
+	// 不備がなければ true を返す
+	return nextValidation.IsEmpty()
+}
+
+// トップページを2回表示して、同じ Post の画像の URL が変わらないことを検証するシナリオ
+// URL が毎回変わるとブラウザや CDN のキャッシュが効かない
+func (s *Scenario) VerifyStableImageURLs(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ログインしていないユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	pages := []map[int]string{}
+	for i := 0; i < 2; i++ {
+		// トップページへのリクエストを実行
+		getRes, err := GetRootAction(ctx, ag)
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			return false
+		}
+		defer getRes.Body.Close()
+
+		urls := map[int]string{}
+		getValidation := ValidateResponse(
+			getRes,
+			// ステータスコードは 200
+			WithStatusCode(200),
+			// Post ごとの画像の URL を取得
+			WithPostImageURLs(urls),
+		)
+		getValidation.Add(step)
+
+		if !getValidation.IsEmpty() {
+			return false
+		}
+		pages = append(pages, urls)
+	}
+
+	// 両方のページに表示された Post の画像の URL を比べる
+	ok := true
+	for id, first := range pages[0] {
+		if second, exists := pages[1][id]; exists && first != second {
+			step.AddError(failure.NewError(
+				ErrUnstableImageURL,
+				fmt.Errorf("GET / : image URL of post %d changed between requests: %s != %s", id, first, second),
+			))
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// 削除されていないユーザーをランダムに選ぶ
+// 該当するユーザーがいなければ nil を返す
+func (s *Scenario) randomActiveUser() *User {
+	return s.randomUserWhere(func(user *User) bool {
+		return user.DeleteFlag == 0
+	})
+}
+
+// 削除されていない管理者でないユーザーをランダムに選ぶ
+// 該当するユーザーがいなければ nil を返す
+func (s *Scenario) randomActiveNonAdminUser() *User {
+	return s.randomUserWhere(func(user *User) bool {
+		return user.DeleteFlag == 0 && user.Authority == 0
+	})
+}
+
+// 条件に合うユーザーの中からランダムに選ぶ
+// 該当するユーザーがいなければ nil を返す
+func (s *Scenario) randomUserWhere(match func(user *User) bool) *User {
+	candidates := []*User{}
+	s.Users.ForEach(func(_ int, user *User) {
+		if match(user) {
+			candidates = append(candidates, user)
+		}
+	})
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// フラッシュメッセージが一度表示されたら消えることを検証するシナリオ
+func (s *Scenario) VerifyFlashCleared(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	user := s.randomActiveUser()
+	if user == nil {
+		AdminLogger.Printf("flash: no active user, skipped")
+		return true
+	}
+
+	// ユーザーのセッションに影響しないように新しいユーザーエージェントを生成
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	// 間違ったパスワードでログインしてフラッシュメッセージを発生させる
+	postRes, err := PostLoginAction(ctx, ag, user.AccountName, user.Password+".invalid")
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+		// リダイレクト先はログインページ
+		WithLocation("/login"),
+	)
+	postValidation.Add(step)
+
+	if !postValidation.IsEmpty() {
+		return false
+	}
+
+	// 1回目はフラッシュメッセージが表示される
+	firstRes, err := GetLoginAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer firstRes.Body.Close()
+
+	firstValidation := ValidateResponse(
+		firstRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 適切なエラーメッセージが含まれていること
+		WithIncludeBody("アカウント名かパスワードが間違っています"),
+	)
+	firstValidation.Add(step)
+
+	if !firstValidation.IsEmpty() {
+		return false
+	}
+
+	// 2回目はフラッシュメッセージが消えている
+	secondRes, err := GetLoginAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer secondRes.Body.Close()
+
+	secondValidation := ValidateResponse(
+		secondRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 表示済みのエラーメッセージが残っていないこと
+		WithoutIncludeBody("アカウント名かパスワードが間違っています", ErrStickyFlash),
+	)
+	secondValidation.Add(step)
+
+	// 不備がなければ true を返す
+	return secondValidation.IsEmpty()
+}
+
+// 管理者でないユーザーが BAN 管理画面を表示できないことを検証するシナリオ
+func (s *Scenario) VerifyAdminBannedGated(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// 管理者でないユーザーを選ぶ
+	user := s.randomActiveNonAdminUser()
+	if user == nil {
+		AdminLogger.Printf("admin banned: no active non-admin user, skipped")
+		return true
+	}
+
+	// 一般ユーザーとしてログイン
+	ag, ok := s.loginWithNewAgent(ctx, step, user)
+	if !ok {
+		return false
+	}
+
+	// BAN 管理画面へのリクエストを実行
+	adminRes, err := GetAdminBannedAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer adminRes.Body.Close()
+
+	adminValidation := ValidateResponse(
+		adminRes,
+		// 管理画面が表示されないこと
+		WithoutAdminPage(),
+	)
+	adminValidation.Add(step)
+
+	// 不備がなければ true を返す
+	return adminValidation.IsEmpty()
+}
+
+// ユーザーのセッションに影響しないように新しいユーザーエージェントでログイン
+// 検証のためのリクエストなのでスコアは加算しない
+func (s *Scenario) loginWithNewAgent(ctx context.Context, step *isucandar.BenchmarkStep, user *User) (*agent.Agent, bool) {
+	ag, err := s.Option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return nil, false
+	}
+
+	return ag, s.loginWithAgent(ctx, step, ag, user)
+}
+
+// 指定したユーザーエージェントでログインする
+func (s *Scenario) loginWithAgent(ctx context.Context, step *isucandar.BenchmarkStep, ag *agent.Agent, user *User) bool {
+	postRes, err := PostLoginAction(ctx, ag, user.AccountName, user.Password)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// ステータスコードは 302
+		WithStatusCode(302),
+		// リダイレクト先はトップページ
+		WithLocation("/"),
+	)
+	postValidation.Add(step)
+
+	return postValidation.IsEmpty()
+}
+
+// 検証用のユーザーで画像を投稿する
+// 投稿が拒否されたときは Post.ID が 0 のまま返す
+func (s *Scenario) postProbeImage(ctx context.Context, step *isucandar.BenchmarkStep, img []byte, filename string, contentType string) (*agent.Agent, *Post, *http.Response, bool) {
+	// ユーザーの CSRF トークンを上書きしないように複製して使う
+	active := s.randomActiveUser()
+	if active == nil {
+		AdminLogger.Printf("image probe: no active user, skipped")
+		return nil, nil, nil, false
+	}
+	user := &User{ID: active.ID, AccountName: active.AccountName, Password: active.Password}
+
+	ag, ok := s.loginWithNewAgent(ctx, step, user)
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	// 投稿フォームの CSRF トークンを取得
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return nil, nil, nil, false
+	}
+	defer getRes.Body.Close()
+
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// CSRF トークンを取得
+		WithCSRFToken(user),
+	)
+	getValidation.Add(step)
+
+	if !getValidation.IsEmpty() {
+		return nil, nil, nil, false
+	}
+
+	post := &Post{
+		Mime:   contentType,
+		Body:   randomText(),
+		UserID: user.ID,
+	}
+	postRes, err := PostRootWithImageAction(ctx, ag, post, img, filename, contentType, user.GetCSRFToken())
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return nil, nil, nil, false
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// 拒否してもよいが、サーバーエラーにはならないこと
+		WithoutServerError(),
+	)
+	postValidation.Add(step)
+
+	if !postValidation.IsEmpty() {
+		return nil, nil, nil, false
+	}
+
+	// リダイレクト先から Post の ID を取得
+	if !ValidateResponse(postRes, WithPostLocation(post)).IsEmpty() {
+		post.ID = 0
+	}
+
+	return ag, post, postRes, true
+}
+
+// 拡張子と中身が一致しない画像を投稿したときの挙動を調べるシナリオ
+// 拒否するか中身に合った Content-Type で配信するのが望ましいが、
+// 元の実装も申告された Content-Type を信用するので結果は大会運営向けに出力するだけにする
+func (s *Scenario) VerifyImageContentSniffing(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// PNG の中身を JPEG と申告して投稿
+	img, err := randomImage()
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	sniffed := http.DetectContentType(img)
+
+	ag, post, postRes, ok := s.postProbeImage(ctx, step, img, "image.jpg", "image/jpeg")
+	if !ok {
+		return false
+	}
+
+	// 投稿が作成されなければ拒否されたものとみなす
+	if post.ID == 0 {
+		AdminLogger.Printf("image sniffing: mismatched upload is rejected: status(%d), Location(%s)", postRes.StatusCode, postRes.Header.Get("Location"))
+		return true
+	}
+
+	// 個別ページから画像の URL を取得
+	detailRes, err := GetPostAction(ctx, ag, post.ID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer detailRes.Body.Close()
+
+	src := ""
+	detailValidation := ValidateResponse(
+		detailRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 画像の URL を取得
+		WithPostImageURL(post.ID, &src),
+	)
+	detailValidation.Add(step)
+
+	if !detailValidation.IsEmpty() {
+		return false
+	}
+
+	// 画像を取得して配信された Content-Type を調べる
+	imageRes, err := RequestAction(ctx, ag, http.MethodGet, src)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer imageRes.Body.Close()
+
+	imageValidation := ValidateResponse(
+		imageRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+	)
+	imageValidation.Add(step)
+
+	if !imageValidation.IsEmpty() {
+		return false
+	}
+
+	served := imageRes.Header.Get("Content-Type")
+	if served != sniffed {
+		AdminLogger.Printf("image sniffing: post %d: %s is served as %s, but content is %s", post.ID, src, served, sniffed)
+	} else {
+		AdminLogger.Printf("image sniffing: post %d: %s is served as sniffed %s", post.ID, src, sniffed)
+	}
+
+	return true
+}
+
+// 一度取得した CSRF トークンが途中で別のページを表示しても使えることを検証するシナリオ
+// ブラウザで開いたままのフォームから送信できなくなるので、無効になっていれば大会運営向けに報告する
+func (s *Scenario) VerifyCSRFTokenPersistence(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ユーザーの CSRF トークンを上書きしないように複製して使う
+	active := s.randomActiveUser()
+	if active == nil {
+		AdminLogger.Printf("csrf persistence: no active user, skipped")
+		return true
+	}
+	user := &User{ID: active.ID, AccountName: active.AccountName, Password: active.Password}
+
+	ag, ok := s.loginWithNewAgent(ctx, step, user)
+	if !ok {
+		return false
+	}
+
+	// コメントする Post と CSRF トークンを取得
+	post := &Post{}
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 先頭の Post の ID を取得
+		WithLatestPost(post),
+		// CSRF トークンを取得
+		WithCSRFToken(user),
+	)
+	getValidation.Add(step)
+
+	if !getValidation.IsEmpty() {
+		return false
+	}
+	token := user.GetCSRFToken()
+
+	// 関係のないページを表示
+	userRes, err := GetUserPageAction(ctx, ag, user.AccountName)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer userRes.Body.Close()
+
+	userValidation := ValidateResponse(
+		userRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+	)
+	userValidation.Add(step)
+
+	if !userValidation.IsEmpty() {
+		return false
+	}
+
+	// 最初に取得した CSRF トークンでコメントを投稿
+	commentRes, err := PostCommentAction(ctx, ag, post.ID, randomText(), token)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer commentRes.Body.Close()
+
+	commentValidation := ValidateResponse(
+		commentRes,
+		// 拒否されてもサーバーエラーにはならないこと
+		WithoutServerError(),
+	)
+	commentValidation.Add(step)
+
+	if !commentValidation.IsEmpty() {
+		return false
+	}
+
+	// 受け付けられればリダイレクトされる
+	if commentRes.StatusCode != 302 {
+		AdminLogger.Printf("csrf token: token from GET / is rejected by POST /comment after GET /@%s: status(%d)", user.AccountName, commentRes.StatusCode)
+	}
+
+	return true
+}
+
+// 空のコメントが受け付けられないことを検証するシナリオ
+// 投稿前後で個別ページのコメント数が変わらないこと
+func (s *Scenario) VerifyEmptyCommentRejected(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ユーザーの CSRF トークンを上書きしないように複製して使う
+	active := s.randomActiveUser()
+	if active == nil {
+		AdminLogger.Printf("empty comment: no active user, skipped")
+		return true
+	}
+	user := &User{ID: active.ID, AccountName: active.AccountName, Password: active.Password}
+
+	ag, ok := s.loginWithNewAgent(ctx, step, user)
+	if !ok {
+		return false
+	}
+
+	// コメントする Post を決める
+	post := &Post{}
+	if !s.fetchLatestPost(ctx, step, ag, post) {
+		return false
+	}
+
+	// 投稿前のコメント数と CSRF トークンを取得
+	before := 0
+	beforeRes, err := GetPostAction(ctx, ag, post.ID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer beforeRes.Body.Close()
+
+	beforeValidation := ValidateResponse(
+		beforeRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// コメント数を取得
+		WithPostCommentCount(post.ID, &before),
+		// CSRF トークンを取得
+		WithCSRFToken(user),
+	)
+	beforeValidation.Add(step)
+
+	if !beforeValidation.IsEmpty() {
+		return false
+	}
+
+	// 空のコメントを投稿
+	commentRes, err := PostCommentAction(ctx, ag, post.ID, "", user.GetCSRFToken())
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer commentRes.Body.Close()
+
+	commentValidation := ValidateResponse(
+		commentRes,
+		// 拒否の仕方は問わないが、サーバーエラーにはならないこと
+		WithoutServerError(),
+	)
+	commentValidation.Add(step)
+
+	if !commentValidation.IsEmpty() {
+		return false
+	}
+
+	// 投稿後のコメント数を取得
+	after := 0
+	afterRes, err := GetPostAction(ctx, ag, post.ID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer afterRes.Body.Close()
+
+	afterValidation := ValidateResponse(
+		afterRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// コメント数を取得
+		WithPostCommentCount(post.ID, &after),
+	)
+	afterValidation.Add(step)
+
+	if !afterValidation.IsEmpty() {
+		return false
+	}
+
+	// コメント数が増えていれば空のコメントが保存されている
+	if after != before {
+		step.AddError(failure.NewError(
+			ErrInvalidComment,
+			fmt.Errorf("POST /comment : empty comment is stored to post %d: comment count %d -> %d", post.ID, before, after),
+		))
+		return false
+	}
+
+	return true
+}
+
+// 同じ画像が一覧と個別ページで異なる大きさで配信されないことを検証するシナリオ
+// 個別ページでは投稿した原寸のまま配信され、一覧がそれより大きいことはないこと
+func (s *Scenario) VerifyImageSizes(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// 画像の本文がなければ大きさは分からない
+	if !s.Option.FetchImages {
+		AdminLogger.Printf("image size: images are not fetched with -fetch-images=false, skipped")
+		return true
+	}
+
+	img, err := randomImage()
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	original, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+
+	ag, post, postRes, ok := s.postProbeImage(ctx, step, img, "image.png", "image/png")
+	if !ok {
+		return false
+	}
+
+	if post.ID == 0 {
+		step.AddError(failure.NewError(
+			ErrInvalidPath,
+			fmt.Errorf("POST / : image is not posted: status(%d), Location(%s)", postRes.StatusCode, postRes.Header.Get("Location")),
+		))
+		return false
+	}
+
+	// 個別ページから画像の URL を取得
+	detailRes, err := GetPostAction(ctx, ag, post.ID)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer detailRes.Body.Close()
+
+	detailSrc := ""
+	detailValidation := ValidateResponse(
+		detailRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 画像の URL を取得
+		WithPostImageURL(post.ID, &detailSrc),
+	)
+	detailValidation.Add(step)
+
+	if !detailValidation.IsEmpty() {
+		return false
+	}
+
+	detail, ok := s.fetchImageConfig(ctx, step, ag, detailSrc)
+	if !ok {
+		return false
+	}
+
+	if detail.Width != original.Width || detail.Height != original.Height {
+		step.AddError(failure.NewError(
+			ErrInvalidImageSize,
+			fmt.Errorf("GET %s : image of post %d is served as %dx%d, expected original %dx%d", detailSrc, post.ID, detail.Width, detail.Height, original.Width, original.Height),
+		))
+		return false
+	}
+
+	// 一覧から画像の URL を取得
+	indexRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer indexRes.Body.Close()
+
+	urls := map[int]string{}
+	indexValidation := ValidateResponse(
+		indexRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// 画像の URL を取得
+		WithPostImageURLs(urls),
+	)
+	indexValidation.Add(step)
+
+	if !indexValidation.IsEmpty() {
+		return false
+	}
+
+	// 他の投稿に押し出されて一覧にない場合は比べられない
+	indexSrc, found := urls[post.ID]
+	if !found {
+		AdminLogger.Printf("image size: post %d is not on index, skipped", post.ID)
+		return true
+	}
+	if indexSrc == detailSrc {
+		return true
+	}
+
+	index, ok := s.fetchImageConfig(ctx, step, ag, indexSrc)
+	if !ok {
+		return false
+	}
+
+	if index.Width > detail.Width || index.Height > detail.Height {
+		step.AddError(failure.NewError(
+			ErrInvalidImageSize,
+			fmt.Errorf("GET %s : index image of post %d is %dx%d, larger than detail image %dx%d", indexSrc, post.ID, index.Width, index.Height, detail.Width, detail.Height),
+		))
+		return false
+	}
+
+	return true
+}
+
+// 画像を取得して大きさを返す
+func (s *Scenario) fetchImageConfig(ctx context.Context, step *isucandar.BenchmarkStep, ag *agent.Agent, src string) (image.Config, bool) {
+	res, err := RequestAction(ctx, ag, http.MethodGet, src)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return image.Config{}, false
+	}
+	defer res.Body.Close()
+
+	validation := ValidateResponse(
+		res,
+		// ステータスコードは 200
+		WithStatusCode(200),
+	)
+	validation.Add(step)
+
+	if !validation.IsEmpty() {
+		return image.Config{}, false
+	}
+
+	config, _, err := image.DecodeConfig(res.Body)
+	if err != nil {
+		step.AddError(failure.NewError(
+			ErrInvalidImageSize,
+			fmt.Errorf("GET %s : cannot decode image: %v", src, err),
+		))
+		return image.Config{}, false
+	}
+
+	return config, true
+}
+
+// 同じアカウント名で同時にユーザー登録しても両方が成功しないことを検証するシナリオ
+// 一意制約がないか競合状態があると同じアカウント名のユーザーが 2 人作られる
+func (s *Scenario) VerifyConcurrentRegistration(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	user := randomNewUser()
+
+	agents := make([]*agent.Agent, 2)
+	for i := range agents {
+		ag, err := s.Option.NewAgent(false)
+		if err != nil {
+			step.AddError(failure.NewError(ErrCannotNewAgent, err))
+			return false
+		}
+		agents[i] = ag
+	}
+
+	// できるだけ同時に送信されるように揃えてからリクエストを実行
+	responses := make([]*http.Response, len(agents))
+	errs := make([]error, len(agents))
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i, ag := range agents {
+		i, ag := i, ag
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			responses[i], errs[i] = PostRegisterAction(ctx, ag, user.AccountName, user.Password)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for _, res := range responses {
+		if res != nil {
+			defer res.Body.Close()
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			step.AddError(failure.NewError(ErrInvalidRequest, err))
+			return false
+		}
+	}
+
+	succeeded := 0
+	for i, res := range responses {
+		validation := ValidateResponse(
+			res,
+			// 拒否されてもサーバーエラーにはならないこと
+			WithoutServerError(),
+		)
+		validation.Add(step)
+
+		if !validation.IsEmpty() {
+			return false
+		}
+
+		// 成功すればトップページにリダイレクトされる
+		if ValidateResponse(res, WithStatusCode(302), WithLocation("/")).IsEmpty() {
+			succeeded++
+			continue
+		}
+
+		// 拒否は登録ページへのリダイレクトか 4xx で示される
+		// 競合したときにフラッシュメッセージが設定されるとは限らないので、メッセージは検証しない
+		if ValidateResponse(res, WithStatusCode(302), WithLocation("/register")).IsEmpty() ||
+			(res.StatusCode >= 400 && res.StatusCode < 500) {
+			continue
+		}
+
+		step.AddError(failure.NewError(
+			ErrInvalidStatusCode,
+			fmt.Errorf("POST /register : registration %d of %s is neither accepted nor rejected: status(%d), location(%s)", i+1, user.AccountName, res.StatusCode, res.Header.Get("Location")),
+		))
+		return false
+	}
+
+	if succeeded > 1 {
+		step.AddError(failure.NewError(
+			ErrInvalidUser,
+			fmt.Errorf("POST /register : %d concurrent registrations of the same account name succeeded: account_name(%s)", succeeded, user.AccountName),
+		))
+		return false
+	}
+
+	// どちらも成功しなければ重複を確かめられない
+	if succeeded == 0 {
+		AdminLogger.Printf("concurrent registration: both registrations of %s are rejected, skipped", user.AccountName)
+		return true
+	}
+
+	// 登録に成功したアカウントでログインできること
+	_, ok := s.loginWithNewAgent(ctx, step, user)
+	return ok
+}
+
+const (
+	// 大きすぎる画像として送るリクエストの大きさ
+	// 参考実装の上限 10MB の倍にする
+	OversizedUploadSize = 20 << 20
+	// 大きすぎる画像の投稿に応答するまでの上限
+	// 送信にかかる時間も含むので通常のタイムアウトより長くする
+	OversizedUploadTimeout = 10 * time.Second
+)
+
+// 大きすぎる画像の投稿にサーバーエラーやハングせずに応答することを検証するシナリオ
+// 413 や 400 で拒否するか、参考実装のようにリダイレクトしてエラーメッセージを表示すればよい
+// Post として保存して個別ページにリダイレクトするのは誤り
+func (s *Scenario) VerifyOversizedUpload(ctx context.Context, step *isucandar.BenchmarkStep) bool {
+	// ユーザーの CSRF トークンを上書きしないように複製して使う
+	active := s.randomActiveUser()
+	if active == nil {
+		AdminLogger.Printf("oversized upload: no active user, skipped")
+		return true
+	}
+	user := &User{ID: active.ID, AccountName: active.AccountName, Password: active.Password}
+
+	// 送信に時間がかかってもタイムアウトしないユーザーエージェントを生成
+	option := s.Option
+	option.RequestTimeout = OversizedUploadTimeout
+	option.ScenarioTimeouts = nil
+	ag, err := option.NewAgent(false)
+	if err != nil {
+		step.AddError(failure.NewError(ErrCannotNewAgent, err))
+		return false
+	}
+
+	if !s.loginWithAgent(ctx, step, ag, user) {
+		return false
+	}
+
+	// 投稿フォームの CSRF トークンを取得
+	getRes, err := GetRootAction(ctx, ag)
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+	defer getRes.Body.Close()
+
+	getValidation := ValidateResponse(
+		getRes,
+		// ステータスコードは 200
+		WithStatusCode(200),
+		// CSRF トークンを取得
+		WithCSRFToken(user),
+	)
+	getValidation.Add(step)
+
+	if !getValidation.IsEmpty() {
+		return false
+	}
+
+	img, err := randomImage()
+	if err != nil {
+		step.AddError(failure.NewError(ErrInvalidRequest, err))
+		return false
+	}
+
+	post := &Post{
+		Mime:   "image/png",
+		Body:   randomText(),
+		UserID: user.ID,
+	}
+	postRes, err := PostRootWithPaddedImageAction(ctx, ag, post, img, OversizedUploadSize, user.GetCSRFToken())
+	if err != nil {
+		// 応答せずに時間切れになるのはハングしているとみなす
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			step.AddError(failure.NewError(
+				ErrInvalidImageSize,
+				fmt.Errorf("POST / : oversized upload (%d bytes) is not answered within %s", OversizedUploadSize, OversizedUploadTimeout),
+			))
+			return false
+		}
+		// 送信中にコネクションを閉じて拒否するのは誤りではない
+		AdminLogger.Printf("oversized upload: connection is closed without response: %v", err)
+		return true
+	}
+	defer postRes.Body.Close()
+
+	postValidation := ValidateResponse(
+		postRes,
+		// 拒否の仕方は問わないが、サーバーエラーにはならないこと
+		WithoutServerError(),
+	)
+	postValidation.Add(step)
+
+	if !postValidation.IsEmpty() {
+		return false
+	}
+
+	// Post の個別ページにリダイレクトしていれば、上限を確かめずに保存してしまっている
+	if ValidateResponse(postRes, WithPostLocation(post)).IsEmpty() {
+		step.AddError(failure.NewError(
+			ErrInvalidImageSize,
+			fmt.Errorf("POST / : oversized upload (%d bytes) is accepted as post %d", OversizedUploadSize, post.ID),
+		))
+		return false
+	}
+
+	return true
+}